@@ -4,7 +4,7 @@ echo "Creating cmd directory if it doesn't exist..."
 mkdir -p cmd/test-logs
 
 echo "Building test-logs command line tool..."
-go build -o test-logs cmd/test-logs/main.go
+go build -o test-logs ./cmd/test-logs
 
 if [ $? -eq 0 ]; then
     echo "Build successful! You can now use ./test-logs --auth-key <auth-key>"
@@ -15,8 +15,12 @@ if [ $? -eq 0 ]; then
     echo "  --destination <url>     Log destination URL (default: https://ingestion.easylogs.co/logs)"
     echo "  --batch-size <count>    Number of logs to send in each batch (default: 10)"
     echo "  --interval <ms>         Interval between batches in milliseconds (default: 1000)"
+    echo "  --gen <name>[=<rate>]   Run only this generator, e.g. api=200/s (repeatable)"
+    echo "  --rate <rate>           Total rate across the generators, e.g. 5000/s or 2MB/s"
+    echo "  --profile <file>        Load profile shaping the rate over time"
+    echo "  --seed <n>              Seed for reproducible logs (with --clock and --count for fixed datasets)"
     echo ""
-    echo "This tool will send ALL data types (api, db, user, metrics) without exceptions."
+    echo "Without --gen this tool will send ALL data types (api, db, user, metrics)."
     echo ""
     echo "Example:"
     echo "./test-logs --auth-key YOUR_AUTH_KEY --duration 86400 --batch-size 20 --interval 500"
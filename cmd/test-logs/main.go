@@ -1,18 +1,19 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
+
+	"log-generator/generator"
+	"log-generator/logentry"
+	"log-generator/sink"
 )
 
 // Configuration
@@ -22,62 +23,142 @@ var (
 	destination string
 	batchSize  int
 	interval   int
+	sinkSpecs  sink.Specs
+	genSpecs   generator.Specs
+	totalRate  generator.Target
+	resendPath string
+	adaptiveOptions string
+	profilePath string
+	seed       int64
+	clockStart string
+	clockStep  time.Duration
+	count      int
 )
 
-// LogEntry represents a single log entry
-type LogEntry struct {
-	Timestamp   string      `json:"timestamp"`
-	Level       string      `json:"level"`
-	Service     string      `json:"service"`
-	Message     string      `json:"message"`
-	StatusCode  int         `json:"status_code,omitempty"`
-	Method      string      `json:"method,omitempty"`
-	Path        string      `json:"path,omitempty"`
-	Duration    int         `json:"duration,omitempty"`
-	UserID      string      `json:"user_id,omitempty"`
-	Action      string      `json:"action,omitempty"`
-	Metadata    interface{} `json:"metadata,omitempty"`
-	Environment string      `json:"environment"`
-}
+// logSink receives every batch produced by the generators
+var logSink sink.Sink
 
-// Sample data for log generation
-var (
-	logLevels     = []string{"INFO", "WARN", "ERROR", "DEBUG"}
-	environments  = []string{"production", "staging", "development"}
-	apiPaths      = []string{"/api/users", "/api/products", "/api/orders", "/api/auth", "/api/payments"}
-	httpMethods   = []string{"GET", "POST", "PUT", "DELETE"}
-	userActions   = []string{"login", "logout", "purchase", "view_item", "update_profile"}
-	dbOperations  = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
-	services      = []string{"auth-service", "user-service", "payment-service", "inventory-service", "notification-service"}
-	userIDs       = []string{"user123", "user456", "user789", "user101", "user202"}
-)
+// adaptive scales the generation rate with how the sinks cope. It is nil
+// unless --adaptive is given.
+var adaptive *sink.AdaptiveRate
 
-func main() {
-	// Initialize random seed
-	rand.Seed(time.Now().UnixNano())
+// loadProfile shapes the generation rate over time. It is nil unless
+// --profile is given.
+var loadProfile *generator.ProfileRun
 
+// LogEntry represents a single log entry
+type LogEntry = logentry.LogEntry
+
+func main() {
 	// Parse command line flags
 	flag.StringVar(&authKey, "auth-key", "", "Authentication key for the log destination")
 	flag.IntVar(&duration, "duration", 60, "Duration in seconds to run the log generator")
 	flag.StringVar(&destination, "destination", "https://ingestion.easylogs.co/logs", "Log destination URL")
 	flag.IntVar(&batchSize, "batch-size", 10, "Number of logs to send in each batch")
 	flag.IntVar(&interval, "interval", 1000, "Interval between batches in milliseconds")
+	flag.Var(&sinkSpecs, "sink", "Log destination as name?key=value&... instead of --destination, repeat to send to several. Available: "+strings.Join(sink.Names(), ", "))
+	flag.Var(&genSpecs, "gen", "Generator to run as name[=rate][,batch=N], e.g. api=200/s, repeat to run several (default: all at --interval and --batch-size). Available: "+strings.Join(generator.Names(), ", "))
+	flag.Func("rate", "Total rate to hold across the generators, in logs or bytes, e.g. 5000/s or 2MB/s", func(v string) error {
+		var err error
+		totalRate, err = generator.ParseTarget(v)
+		return err
+	})
+	flag.StringVar(&resendPath, "resend", "", "Send the batches recorded in a dead-letter file to the sinks instead of generating logs")
+	flag.StringVar(&profilePath, "profile", "", "Load profile file that shapes the generation rate over time")
+	flag.Int64Var(&seed, "seed", 0, "Seed of the generators' random streams, to reproduce a run (default: random, printed at start)")
+	flag.StringVar(&clockStart, "clock", "", "Timestamp logs from this RFC 3339 time on, advancing by --clock-step per log, instead of the wall clock")
+	flag.DurationVar(&clockStep, "clock-step", 0, "Virtual time between two logs of a generator with --clock (default: from the generator's rate)")
+	flag.IntVar(&count, "count", 0, "Stop each generator after this many logs (default: no limit)")
+	flag.StringVar(&adaptiveOptions, "adaptive", "", "Scale the generation rate down while the sinks struggle: on, or options as key=value&...")
 	flag.Parse()
 
 	// Validate auth key
-	if authKey == "" {
+	if authKey == "" && len(sinkSpecs) == 0 {
 		fmt.Println("Error: Authentication key is required")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	if resendPath != "" {
+		os.Exit(resendDeadLetters(resendPath))
+	}
+
+	if adaptiveOptions != "" {
+		var err error
+		if adaptive, err = sink.ParseAdaptive(adaptiveOptions); err != nil {
+			fmt.Printf("Error configuring adaptive rate: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var profile *generator.Profile
+	if profilePath != "" {
+		var err error
+		if profile, err = generator.LoadProfile(profilePath); err != nil {
+			fmt.Printf("Error loading profile: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if !flagSet("seed") {
+		seed = time.Now().UnixNano()
+	}
+	loops, err := generatorLoops()
+	if err != nil {
+		fmt.Printf("Error configuring generators: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Every destination sends from its own queue, so slow responses never
+	// hold up the generators
+	fanOut := &sink.FanOut{}
+	if len(sinkSpecs) == 0 {
+		var s sink.Sink
+		s, err = sink.New("http", sink.Config{"url": destination, "token": authKey})
+		if err == nil {
+			fanOut.Add("http", s, sink.DefaultQueueSize, 1, false)
+		}
+	} else {
+		fanOut, err = sink.OpenFanOut(sinkSpecs)
+	}
+	if err != nil {
+		fmt.Printf("Error configuring sink: %s\n", err)
+		os.Exit(1)
+	}
+	fanOut.OnError = func(target string, err error) {
+		fmt.Printf("Error sending logs to %s: %s\n", target, err)
+	}
+	if adaptive != nil {
+		fanOut.OnResult = func(target string, took time.Duration, err error) {
+			adaptive.Observe(took, err)
+		}
+	}
+	logSink = fanOut
+
 	// Start log generation
-	fmt.Printf("Starting log generation with auth key: %s\n", authKey)
 	fmt.Printf("Duration: %d seconds\n", duration)
-	fmt.Printf("Destination: %s\n", destination)
-	fmt.Printf("Batch size: %d logs\n", batchSize)
-	fmt.Printf("Interval: %d ms\n", interval)
-	fmt.Println("Sending ALL data types (api, db, user, metrics)")
+	if len(sinkSpecs) > 0 {
+		for _, spec := range sinkSpecs {
+			name, _, _ := strings.Cut(spec, "?")
+			fmt.Printf("Sink: %s\n", name)
+		}
+	} else {
+		fmt.Printf("Starting log generation with auth key: %s\n", authKey)
+		fmt.Printf("Destination: %s\n", destination)
+	}
+	fmt.Printf("Seed: %d\n", seed)
+	if profile != nil {
+		fmt.Printf("Load profile: %s, %d stages over %s\n", profile.Name, len(profile.Stages), profile.Length())
+	}
+	for _, loop := range loops {
+		size := loop.BatchSize()
+		if loop.Target.IsZero() {
+			fmt.Printf("Generator %s: %d logs every %s (%.1f logs/s)\n",
+				loop.Generator.Name(), size, loop.Interval, float64(size)/loop.Interval.Seconds())
+		} else {
+			fmt.Printf("Generator %s: %s in batches of %d logs\n", loop.Generator.Name(), loop.Target, size)
+		}
+	}
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -88,22 +169,39 @@ func main() {
 	
 	// Create a wait group for the generators
 	var wg sync.WaitGroup
-	wg.Add(4)
+	finished := make(chan struct{})
 	
 	// Start the log generators
-	go generateAPILogs(&wg, stopChan)
-	go generateDatabaseLogs(&wg, stopChan)
-	go generateUserActivityLogs(&wg, stopChan)
-	go generateSystemMetrics(&wg, stopChan)
+	loadProfile = profile.Start()
+	for _, loop := range loops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop.Run(stopChan)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	rates := newThroughput(loops, fanOut)
+	go reportStatus(fanOut, rates, stopChan)
 	
-	// Create a timer for the duration
-	timer := time.NewTimer(time.Duration(duration) * time.Second)
+	// Create a timer for the duration. With --count the generators stop on
+	// their own, so only an explicit --duration cuts them short.
+	var timeout <-chan time.Time
+	if count == 0 || flagSet("duration") {
+		timeout = time.After(time.Duration(duration) * time.Second)
+	}
 	
-	// Wait for either duration to expire or signal
+	// Wait for the duration to expire, the generators to finish or a signal
 	select {
-	case <-timer.C:
+	case <-timeout:
 		fmt.Println("Duration completed, stopping log generation...")
 		close(stopChan)
+	case <-finished:
+		fmt.Println("All generators reached --count, stopping log generation...")
+		close(stopChan)
 	case sig := <-sigChan:
 		fmt.Printf("Received signal %v, stopping log generation...\n", sig)
 		close(stopChan)
@@ -111,200 +209,181 @@ func main() {
 	
 	// Wait for all generators to complete
 	wg.Wait()
-	
-	fmt.Println("Log generation stopped successfully")
-}
 
-// Send logs to the destination
-func sendLogs(logs []LogEntry) {
-	// Create a buffer for the JSON array of logs
-	var buf bytes.Buffer
-	
-	// Encode the entire logs array as a single JSON array
-	if err := json.NewEncoder(&buf).Encode(logs); err != nil {
-		fmt.Printf("Error encoding log entries: %s\n", err)
-		return
+	if err := logSink.Close(); err != nil {
+		fmt.Printf("Error closing sink: %s\n", err)
 	}
-	
-	// Create HTTP request to the destination
-	req, err := http.NewRequest("POST", destination, &buf)
-	if err != nil {
-		fmt.Printf("Error creating request: %s\n", err)
-		return
+	for _, ts := range fanOut.TargetStats() {
+		fmt.Printf("Sink %s stats: %s\n", ts.Name, ts.Stats)
 	}
-	
-	// Set required headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+authKey)
-	
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if adaptive != nil {
+		fmt.Printf("Adaptive rate: %s\n", adaptive.State())
 	}
-	
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
+	rates.summary()
+	fmt.Println("Log generation stopped successfully")
+}
+
+// Send logs to the destination. Delivered batches show in the periodic
+// status and the final stats rather than one line each.
+func sendLogs(logs []LogEntry) {
+	if err := logSink.Send(context.Background(), logs); err != nil {
 		fmt.Printf("Error sending logs: %s\n", err)
-		return
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode >= 400 {
-		fmt.Printf("Error from API. Status: %d\n", resp.StatusCode)
-		// Read and log response body for debugging
-		body, _ := ioutil.ReadAll(resp.Body)
-		fmt.Printf("Response: %s\n", string(body))
-	} else {
-		fmt.Printf("Successfully sent %d logs\n", len(logs))
 	}
 }
 
-// Generate API logs
-func generateAPILogs(wg *sync.WaitGroup, stopChan <-chan struct{}) {
-	defer wg.Done()
-	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
-	defer ticker.Stop()
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			logs := make([]LogEntry, batchSize)
-			for i := 0; i < batchSize; i++ {
-				statusCode := []int{200, 201, 400, 401, 403, 404, 500}[rand.Intn(7)]
-				duration := rand.Intn(1000)
+// generatorLoops builds a loop for every --gen flag, or for every generator
+// at --interval and --batch-size when there are none. Generators with a rate
+// run open loop. --rate is split across the generators, evenly or weighted by
+// the rates of their --gen flags.
+//
+// Every generator draws from its own random stream of --seed, named after
+// it, so that the same seed and flags give every generator the same
+// sequence of logs.
+func generatorLoops() ([]generator.Loop, error) {
+	specs := genSpecs
+	if len(specs) == 0 {
+		for _, name := range generator.Names() {
+			specs = append(specs, generator.Spec{Name: name})
+		}
+	}
 
-				logs[i] = LogEntry{
-					Timestamp:   time.Now().Format(time.RFC3339),
-					Level:       logLevels[rand.Intn(len(logLevels))],
-					Service:     services[rand.Intn(len(services))],
-					Message:     fmt.Sprintf("HTTP %s %s completed in %dms with status %d", 
-						httpMethods[rand.Intn(len(httpMethods))],
-						apiPaths[rand.Intn(len(apiPaths))],
-						duration,
-						statusCode),
-					StatusCode:  statusCode,
-					Method:      httpMethods[rand.Intn(len(httpMethods))],
-					Path:        apiPaths[rand.Intn(len(apiPaths))],
-					Duration:    duration,
-					Environment: environments[rand.Intn(len(environments))],
-				}
+	targets := make([]generator.Target, len(specs))
+	var weights float64
+	for i, spec := range specs {
+		targets[i] = spec.Target
+		weights += spec.Target.Value()
+	}
+	if !totalRate.IsZero() {
+		for i, spec := range specs {
+			switch {
+			case weights == 0:
+				targets[i] = totalRate.Scale(1 / float64(len(specs)))
+			case spec.Target.IsZero():
+				return nil, fmt.Errorf("with --rate either all or none of the --gen flags give a rate")
+			default:
+				targets[i] = totalRate.Scale(spec.Target.Value() / weights)
 			}
-			sendLogs(logs)
-		case <-stopChan:
-			return
 		}
 	}
-}
 
-// Generate database logs
-func generateDatabaseLogs(wg *sync.WaitGroup, stopChan <-chan struct{}) {
-	defer wg.Done()
-	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			logs := make([]LogEntry, batchSize)
-			for i := 0; i < batchSize; i++ {
-				duration := rand.Intn(500)
-				operation := dbOperations[rand.Intn(len(dbOperations))]
-				table := []string{"users", "products", "orders", "payments", "inventory"}[rand.Intn(5)]
+	var start time.Time
+	if clockStart != "" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, clockStart); err != nil {
+			return nil, fmt.Errorf("--clock must be an RFC 3339 time such as 2024-01-01T00:00:00Z, got %q", clockStart)
+		}
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("--count must not be negative")
+	}
 
-				logs[i] = LogEntry{
-					Timestamp:   time.Now().Format(time.RFC3339),
-					Level:       logLevels[rand.Intn(len(logLevels))],
-					Service:     services[rand.Intn(len(services))],
-					Message:     fmt.Sprintf("Database operation %s on table %s completed in %dms", 
-						operation, table, duration),
-					Duration:    duration,
-					Method:      operation,
-					Path:        table,
-					Environment: environments[rand.Intn(len(environments))],
-					Metadata:    map[string]interface{}{
-						"table":     table,
-						"operation": operation,
-						"rows":      rand.Intn(100) + 1,
-					},
-				}
+	loops := make([]generator.Loop, 0, len(specs))
+	streams := make(map[string]int)
+	for i, spec := range specs {
+		// A generator given twice gets a second stream
+		stream := spec.Name
+		if streams[spec.Name]++; streams[spec.Name] > 1 {
+			stream = fmt.Sprintf("%s#%d", spec.Name, streams[spec.Name])
+		}
+		src := generator.Seeded(seed, stream)
+		size := batchSize
+		if spec.BatchSize > 0 {
+			size = spec.BatchSize
+		}
+		if size < 1 {
+			return nil, fmt.Errorf("generator %s: batch size must be at least 1", spec.Name)
+		}
+		every := time.Duration(interval) * time.Millisecond
+		if targets[i].IsZero() && every <= 0 {
+			return nil, fmt.Errorf("generator %s: interval must be positive", spec.Name)
+		}
+		if clockStart != "" {
+			step := clockStep
+			if step <= 0 {
+				step = nominalStep(targets[i], every, size)
 			}
-			sendLogs(logs)
-		case <-stopChan:
-			return
+			src.Now = generator.NewVirtualClock(start, step).Now
+		}
+		gen, err := generator.New(spec.Name, src)
+		if err != nil {
+			return nil, err
 		}
+		loops = append(loops, generator.Loop{
+			Generator: gen,
+			Interval:  every,
+			BatchSize: func() int { return size },
+			Target:    targets[i],
+			Scale:     generationScale,
+			Emit:      sendLogs,
+			Meter:     &generator.Meter{},
+			Limit:     count,
+		})
 	}
+	return loops, nil
 }
 
-// Generate user activity logs
-func generateUserActivityLogs(wg *sync.WaitGroup, stopChan <-chan struct{}) {
-	defer wg.Done()
-	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			logs := make([]LogEntry, batchSize)
-			for i := 0; i < batchSize; i++ {
-				userID := userIDs[rand.Intn(len(userIDs))]
-				action := userActions[rand.Intn(len(userActions))]
-
-				logs[i] = LogEntry{
-					Timestamp:   time.Now().Format(time.RFC3339),
-					Level:       "INFO",
-					Service:     "user-activity-service",
-					Message:     fmt.Sprintf("User %s performed action: %s", userID, action),
-					UserID:      userID,
-					Action:      action,
-					Environment: environments[rand.Intn(len(environments))],
-					Metadata:    map[string]interface{}{
-						"browser":  []string{"Chrome", "Firefox", "Safari", "Edge"}[rand.Intn(4)],
-						"platform": []string{"Windows", "MacOS", "Linux", "iOS", "Android"}[rand.Intn(5)],
-						"ip":       fmt.Sprintf("192.168.%d.%d", rand.Intn(255), rand.Intn(255)),
-					},
-				}
-			}
-			sendLogs(logs)
-		case <-stopChan:
-			return
-		}
+// nominalStep returns the time between two logs of a generator running at
+// target, or at size logs every interval without one. Byte targets have no
+// fixed time per log, they get a millisecond.
+func nominalStep(target generator.Target, interval time.Duration, size int) time.Duration {
+	switch {
+	case target.Logs > 0:
+		return time.Duration(float64(time.Second) / target.Logs)
+	case target.Bytes > 0:
+		return time.Millisecond
 	}
+	return interval / time.Duration(size)
 }
 
-// Generate system metrics
-func generateSystemMetrics(wg *sync.WaitGroup, stopChan <-chan struct{}) {
-	defer wg.Done()
-	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+// generationScale returns the fraction of the configured rate to produce: the
+// load profile factor, lowered further by the adaptive rate
+func generationScale() float64 {
+	return loadProfile.Scale() * adaptive.Rate()
+}
+
+// reportStatus prints circuit breaker transitions, adaptive rate changes and
+// load profile stages once a second, and shortfalls against the rate targets
+// every throughputWindow, until stopChan is closed
+func reportStatus(fanOut *sink.FanOut, rates *throughput, stopChan <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
+	window := time.NewTicker(throughputWindow)
+	defer window.Stop()
 
+	breakers := make(map[string]string)
+	rate := 1.0
+	var stage generator.ProfileState
 	for {
 		select {
 		case <-ticker.C:
-			logs := make([]LogEntry, batchSize)
-			for i := 0; i < batchSize; i++ {
-				cpuUsage := rand.Float64() * 100
-				memoryUsage := rand.Float64() * 100
-				diskUsage := rand.Float64() * 100
-
-				logs[i] = LogEntry{
-					Timestamp:   time.Now().Format(time.RFC3339),
-					Level:       "INFO",
-					Service:     "system-metrics",
-					Message:     fmt.Sprintf("System metrics: CPU: %.2f%%, Memory: %.2f%%, Disk: %.2f%%", 
-						cpuUsage, memoryUsage, diskUsage),
-					Environment: environments[rand.Intn(len(environments))],
-					Metadata:    map[string]interface{}{
-						"cpu":    cpuUsage,
-						"memory": memoryUsage,
-						"disk":   diskUsage,
-						"host":   fmt.Sprintf("server-%d", rand.Intn(10)+1),
-					},
+			for _, ts := range fanOut.TargetStats() {
+				state, last := ts.Stats.Breaker, breakers[ts.Name]
+				if state != last && (last != "" || state != sink.BreakerClosed) {
+					fmt.Printf("Sink %s circuit breaker %s\n", ts.Name, state)
 				}
+				breakers[ts.Name] = state
 			}
-			sendLogs(logs)
+			if state := adaptive.State(); state.Rate != rate {
+				fmt.Printf("Adaptive rate: %s\n", state)
+				rate = state.Rate
+			}
+			if state := loadProfile.State(); loadProfile != nil && (state.Stage != stage.Stage || state.Done != stage.Done) {
+				fmt.Printf("Load profile: %s\n", state)
+				stage = state
+			}
+		case <-window.C:
+			rates.check()
 		case <-stopChan:
 			return
 		}
 	}
-} 
\ No newline at end of file
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"log-generator/sink"
+)
+
+// resendDeadLetters sends every batch recorded in the dead-letter file at
+// path to each configured sink, one batch at a time, and returns the exit
+// code. Batches rejected again are written to the dead-letter file of the
+// sink spec, if it names one.
+func resendDeadLetters(path string) int {
+	specs := []string(sinkSpecs)
+	if len(specs) == 0 {
+		specs = []string{"http?" + url.Values{"url": {destination}, "token": {authKey}}.Encode()}
+	}
+
+	type target struct {
+		name string
+		sink sink.Sink
+	}
+	var targets []target
+	defer func() {
+		for _, t := range targets {
+			t.sink.Close()
+		}
+	}()
+	for _, spec := range specs {
+		name, cfg, err := sink.ParseSpec(spec)
+		if err != nil {
+			fmt.Printf("Error configuring sink: %s\n", err)
+			return 1
+		}
+		// Batches rejected again would be appended to the file being read,
+		// and read back, forever
+		if deadLetter := cfg.String("dead_letter", ""); deadLetter != "" && samePath(deadLetter, path) {
+			fmt.Printf("Error configuring sink %s: dead_letter is the file being resent, move %s aside first\n", name, path)
+			return 1
+		}
+		s, err := sink.New(name, cfg)
+		if err != nil {
+			fmt.Printf("Error configuring sink: %s\n", err)
+			return 1
+		}
+		targets = append(targets, target{name: name, sink: s})
+	}
+
+	var total, resent int
+	err := sink.ReadDeadLetters(path, func(letter sink.DeadLetter) error {
+		total++
+		ok := true
+		for _, t := range targets {
+			if err := t.sink.Send(context.Background(), letter.Logs); err != nil {
+				fmt.Printf("Error resending %d logs rejected by %s at %s to %s: %s\n",
+					len(letter.Logs), letter.Sink, letter.Time.Format(time.RFC3339), t.name, err)
+				ok = false
+			}
+		}
+		if ok {
+			resent++
+			fmt.Printf("Resent %d logs rejected by %s at %s\n", len(letter.Logs), letter.Sink, letter.Time.Format(time.RFC3339))
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("Error reading dead letters: %s\n", err)
+		return 1
+	}
+	for _, t := range targets {
+		if err := t.sink.Flush(context.Background()); err != nil {
+			fmt.Printf("Error flushing sink %s: %s\n", t.name, err)
+			return 1
+		}
+	}
+	fmt.Printf("Resent %d of %d batches from %s\n", resent, total, path)
+	if resent < total {
+		return 1
+	}
+	return 0
+}
+
+// samePath reports whether a and b name the same file, by path or, for
+// existing files, by identity so that links are caught too
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
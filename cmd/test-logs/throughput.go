@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"log-generator/generator"
+	"log-generator/sink"
+)
+
+// throughputWindow is how long achieved rates are averaged over before they
+// are compared with the targets
+const throughputWindow = 5 * time.Second
+
+// throughput compares what the open-loop generators produce, and what the
+// sinks deliver, with what was due at the target rates
+type throughput struct {
+	loops  []generator.Loop
+	fanOut *sink.FanOut
+	start  time.Time
+
+	last    time.Time
+	entries []int64
+	bytes   []int64
+	due     []float64
+	// delivered holds the entries each fan-out target had delivered at the
+	// last check
+	delivered []int64
+}
+
+func newThroughput(loops []generator.Loop, fanOut *sink.FanOut) *throughput {
+	now := time.Now()
+	return &throughput{
+		loops:     loops,
+		fanOut:    fanOut,
+		start:     now,
+		last:      now,
+		entries:   make([]int64, len(loops)),
+		bytes:     make([]int64, len(loops)),
+		due:       make([]float64, len(loops)),
+		delivered: make([]int64, len(fanOut.TargetStats())),
+	}
+}
+
+// check prints every generator that fell more than 5% short of its target
+// since the last check and, when all targets count logs, every sink that
+// delivered less than their sum. Each sink gets every entry, so each is
+// compared with the whole due rate on its own.
+func (t *throughput) check() {
+	now := time.Now()
+	elapsed := now.Sub(t.last)
+	t.last = now
+
+	var dueLogs float64
+	allLogs := true
+	for i, loop := range t.loops {
+		entries, bytes := loop.Meter.Totals()
+		due := loop.Meter.Due()
+		dEntries, dBytes, dDue := entries-t.entries[i], bytes-t.bytes[i], due-t.due[i]
+		t.entries[i], t.bytes[i], t.due[i] = entries, bytes, due
+		if loop.Target.IsZero() {
+			continue
+		}
+		allLogs = allLogs && loop.Target.Bytes == 0
+		dueLogs += dDue
+
+		achieved := loop.Target.Achieved(dEntries, dBytes, elapsed)
+		if want := dDue / elapsed.Seconds(); achieved < 0.95*want {
+			fmt.Printf("Generator %s behind target: %s of %s\n",
+				loop.Generator.Name(), rateOf(loop.Target, achieved), rateOf(loop.Target, want))
+		}
+	}
+
+	want := dueLogs / elapsed.Seconds()
+	for i, ts := range t.fanOut.TargetStats() {
+		delivered := float64(ts.Stats.Entries-t.delivered[i]) / elapsed.Seconds()
+		t.delivered[i] = ts.Stats.Entries
+		if allLogs && want > 0 && delivered < 0.95*want {
+			fmt.Printf("Sink %s behind target: delivered %.1f logs/s of %.1f logs/s\n", ts.Name, delivered, want)
+		}
+	}
+}
+
+// summary prints the average rate of every generator with a target
+func (t *throughput) summary() {
+	elapsed := time.Since(t.start)
+	for _, loop := range t.loops {
+		if loop.Target.IsZero() {
+			continue
+		}
+		entries, bytes := loop.Meter.Totals()
+		achieved := loop.Target.Achieved(entries, bytes, elapsed)
+		want := loop.Meter.Due() / elapsed.Seconds()
+		fmt.Printf("Generator %s averaged %s (target %s)\n",
+			loop.Generator.Name(), rateOf(loop.Target, achieved), rateOf(loop.Target, want))
+	}
+}
+
+// rateOf formats v in the unit of target
+func rateOf(target generator.Target, v float64) generator.Target {
+	return target.Scale(v / target.Value())
+}
@@ -0,0 +1,41 @@
+package generator
+
+import (
+	"fmt"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("api", func(src Source) Generator { return apiGenerator{src} })
+}
+
+// apiGenerator produces HTTP request logs
+type apiGenerator struct{ Source }
+
+func (apiGenerator) Name() string { return "api" }
+
+func (g apiGenerator) Generate(n int) []logentry.LogEntry {
+	logs := make([]logentry.LogEntry, n)
+	for i := range logs {
+		statusCode := pick(g.Rand, statusCodes)
+		duration := g.Rand.Intn(1000)
+		method := pick(g.Rand, httpMethods)
+		path := pick(g.Rand, apiPaths)
+
+		logs[i] = logentry.LogEntry{
+			Timestamp:   g.Now().Format(time.RFC3339),
+			Level:       pick(g.Rand, logLevels),
+			Service:     pick(g.Rand, services),
+			Message:     fmt.Sprintf("HTTP %s %s completed in %dms with status %d", method, path, duration, statusCode),
+			StatusCode:  statusCode,
+			Method:      method,
+			Path:        path,
+			Duration:    duration,
+			Environment: pick(g.Rand, environments),
+			Generator:   "api",
+		}
+	}
+	return logs
+}
@@ -0,0 +1,31 @@
+package generator
+
+import (
+	"sync"
+	"time"
+)
+
+// VirtualClock hands out timestamps that start at a fixed time and advance
+// by a fixed step on every call, so that entries carry the same timestamps
+// however fast or slow they are generated. Its Now method serves as
+// Source.Now.
+type VirtualClock struct {
+	mu   sync.Mutex
+	next time.Time
+	step time.Duration
+}
+
+// NewVirtualClock returns a clock whose first timestamp is start
+func NewVirtualClock(start time.Time, step time.Duration) *VirtualClock {
+	return &VirtualClock{next: start, step: step}
+}
+
+// Now returns the current virtual time and advances the clock by one step
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.next
+	c.next = c.next.Add(c.step)
+	return now
+}
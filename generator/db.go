@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"fmt"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("db", func(src Source) Generator { return dbGenerator{src} })
+}
+
+// dbGenerator produces database query logs
+type dbGenerator struct{ Source }
+
+func (dbGenerator) Name() string { return "db" }
+
+func (g dbGenerator) Generate(n int) []logentry.LogEntry {
+	logs := make([]logentry.LogEntry, n)
+	for i := range logs {
+		duration := g.Rand.Intn(500)
+		operation := pick(g.Rand, dbOperations)
+		table := pick(g.Rand, dbTables)
+
+		logs[i] = logentry.LogEntry{
+			Timestamp:   g.Now().Format(time.RFC3339),
+			Level:       pick(g.Rand, logLevels),
+			Service:     pick(g.Rand, services),
+			Message:     fmt.Sprintf("Database operation %s on table %s completed in %dms", operation, table, duration),
+			Duration:    duration,
+			Method:      operation,
+			Path:        table,
+			Environment: pick(g.Rand, environments),
+			Generator:   "db",
+			Metadata: map[string]interface{}{
+				"table":     table,
+				"operation": operation,
+				"rows":      g.Rand.Intn(100) + 1,
+			},
+		}
+	}
+	return logs
+}
@@ -0,0 +1,273 @@
+// Package generator produces the synthetic log entries sent by both the web
+// server and the test-logs command line tool.
+//
+// Generators register themselves by name (api, db, user and metrics), so
+// that every binary, and tests, draw from one implementation instead of
+// keeping copies that drift apart.
+package generator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// Generator produces log entries of one kind. A generator is used by one
+// goroutine at a time.
+type Generator interface {
+	// Name is the name the generator is registered under. Every entry it
+	// produces carries it in LogEntry.Generator.
+	Name() string
+	// Generate returns n new entries
+	Generate(n int) []logentry.LogEntry
+}
+
+// Source is where a generator draws its randomness and timestamps from.
+// Generators take nothing from elsewhere, so that two generators built from
+// equal sources produce identical entries.
+type Source struct {
+	Rand *rand.Rand
+	// Now returns the timestamp of the next entry. It is called once per
+	// entry.
+	Now func() time.Time
+}
+
+// Seeded returns a Source with the random stream of seed named stream and
+// the wall clock. The same seed and stream always give the same sequence,
+// and streams of different names are independent of each other.
+func Seeded(seed int64, stream string) Source {
+	h := fnv.New64a()
+	h.Write([]byte(stream))
+	return Source{Rand: rand.New(rand.NewSource(seed ^ int64(h.Sum64()))), Now: time.Now}
+}
+
+// Factory builds a generator drawing from src
+type Factory func(src Source) Generator
+
+var (
+	registryMux sync.RWMutex
+	registry    = make(map[string]Factory)
+)
+
+// Register makes a generator available under name. It panics if name is
+// already taken, as two generators claiming one name is a programming error.
+func Register(name string, factory Factory) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+
+	if _, dup := registry[name]; dup {
+		panic("generator: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Names lists the registered generator names in sorted order
+func Names() []string {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookup(name string) (Factory, error) {
+	registryMux.RLock()
+	factory, ok := registry[name]
+	registryMux.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown generator %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return factory, nil
+}
+
+// New builds the generator registered under name, drawing from src. A nil
+// src.Rand is seeded from the time and a nil src.Now is the wall clock.
+func New(name string, src Source) (Generator, error) {
+	factory, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if src.Rand == nil {
+		src.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	if src.Now == nil {
+		src.Now = time.Now
+	}
+	return factory(src), nil
+}
+
+// maxBacklog bounds how far an open-loop run catches up after falling
+// behind; anything older is given up and shows as a shortfall
+const maxBacklog = time.Second
+
+// Loop drives a generator. Without a Target it produces one batch per
+// Interval. With one it runs open loop: batches are due by the clock
+// according to the target rate, and a loop that fell behind, e.g. because
+// Emit blocked, catches up on the batches it missed.
+type Loop struct {
+	Generator Generator
+	// Interval is the tick of a loop without a Target
+	Interval time.Duration
+	// BatchSize returns the number of entries for the next batch
+	BatchSize func() int
+	// Target is the rate of an open-loop run
+	Target Target
+	// Scale, if set, returns the fraction of the rate to produce, e.g.
+	// sink.AdaptiveRate.Rate
+	Scale func() float64
+	// Emit receives every batch
+	Emit func(logs []logentry.LogEntry)
+	// Meter, if set, counts what the loop produces
+	Meter *Meter
+	// Limit, if set, stops the loop after it produced this many entries
+	Limit int
+}
+
+// Run produces batches until stop is closed or the loop reached its Limit
+func (l Loop) Run(stop <-chan struct{}) {
+	if l.Target.IsZero() {
+		l.runTicker(stop)
+		return
+	}
+	l.runOpen(stop)
+}
+
+func (l Loop) scale() float64 {
+	if l.Scale == nil {
+		return 1
+	}
+	return l.Scale()
+}
+
+// nextSize returns the size of the next batch, cut short by Limit
+func (l Loop) nextSize(produced int) int {
+	n := l.BatchSize()
+	if l.Limit > 0 {
+		n = min(n, l.Limit-produced)
+	}
+	return n
+}
+
+// done reports whether the loop reached its Limit
+func (l Loop) done(produced int) bool {
+	return l.Limit > 0 && produced >= l.Limit
+}
+
+func (l Loop) emit(logs []logentry.LogEntry) int {
+	size := 0
+	if l.Target.Bytes > 0 {
+		size = encodedSize(logs)
+	}
+	l.Meter.add(len(logs), size)
+	l.Emit(logs)
+	return size
+}
+
+// runTicker produces Scale batches per tick on average: one on a fraction of
+// the ticks below 1, several per tick above
+func (l Loop) runTicker(stop <-chan struct{}) {
+	ticker := time.NewTicker(l.Interval)
+	defer ticker.Stop()
+
+	var credit float64
+	produced := 0
+	for {
+		select {
+		case <-ticker.C:
+			for credit += l.scale(); credit >= 1; credit-- {
+				n := l.nextSize(produced)
+				l.emit(l.Generator.Generate(n))
+				if produced += n; l.done(produced) {
+					return
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// runOpen keeps a budget of entries or bytes that grows with the target
+// rate as time passes, and spends it on batches
+func (l Loop) runOpen(stop <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	var budget, batchCost float64
+	produced := 0
+	last := time.Now()
+	for {
+		select {
+		case <-timer.C:
+		case <-stop:
+			return
+		}
+		now := time.Now()
+		rate := l.Target.Scale(l.scale()).Value()
+		due := now.Sub(last).Seconds() * rate
+		l.Meter.addDue(due)
+		budget = min(budget+due, rate*maxBacklog.Seconds()+batchCost)
+		last = now
+		if rate <= 0 {
+			// Paused, e.g. by a load profile, check again shortly
+			budget = 0
+			timer.Reset(100 * time.Millisecond)
+			continue
+		}
+
+		for budget >= batchCost {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			logs := l.Generator.Generate(l.nextSize(produced))
+			cost := float64(len(logs))
+			if size := l.emit(logs); l.Target.Bytes > 0 {
+				cost = float64(size)
+			}
+			if produced += len(logs); l.done(produced) {
+				return
+			}
+			budget -= cost
+			// Byte targets cannot know the size of a batch in advance,
+			// so wait for about as much budget as the last one took
+			batchCost = cost
+		}
+
+		wait := time.Duration((batchCost - budget) / rate * float64(time.Second))
+		timer.Reset(min(max(wait, time.Millisecond), 100*time.Millisecond))
+	}
+}
+
+// Sample data shared by the generators
+var (
+	logLevels    = []string{"INFO", "WARN", "ERROR", "DEBUG"}
+	environments = []string{"production", "staging", "development"}
+	apiPaths     = []string{"/api/users", "/api/products", "/api/orders", "/api/auth", "/api/payments"}
+	httpMethods  = []string{"GET", "POST", "PUT", "DELETE"}
+	userActions  = []string{"login", "logout", "purchase", "view_item", "update_profile"}
+	dbOperations = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
+	dbTables     = []string{"users", "products", "orders", "payments", "inventory"}
+	services     = []string{"auth-service", "user-service", "payment-service", "inventory-service", "notification-service"}
+	userIDs      = []string{"user123", "user456", "user789", "user101", "user202"}
+	statusCodes  = []int{200, 201, 400, 401, 403, 404, 500}
+	browsers     = []string{"Chrome", "Firefox", "Safari", "Edge"}
+	platforms    = []string{"Windows", "MacOS", "Linux", "iOS", "Android"}
+)
+
+// pick returns a random element of values
+func pick[T any](r *rand.Rand, values []T) T {
+	return values[r.Intn(len(values))]
+}
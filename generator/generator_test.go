@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// run produces limit entries of the generator name the way test-logs does
+// with --seed, --clock and --count, and returns them as JSON
+func run(t *testing.T, name string, seed int64, stream string, limit int) []byte {
+	t.Helper()
+	src := Seeded(seed, stream)
+	src.Now = NewVirtualClock(time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC), 10*time.Millisecond).Now
+	gen, err := New(name, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []logentry.LogEntry
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Loop{
+			Generator: gen,
+			Interval:  time.Millisecond,
+			BatchSize: func() int { return 7 },
+			Emit:      func(batch []logentry.LogEntry) { logs = append(logs, batch...) },
+			Limit:     limit,
+		}.Run(stop)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		close(stop)
+		<-done
+		t.Fatalf("%s: loop did not stop at its limit", name)
+	}
+
+	if len(logs) != limit {
+		t.Fatalf("%s: got %d entries, want %d", name, len(logs), limit)
+	}
+	out, err := json.Marshal(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestSeededRunsAreReproducible(t *testing.T) {
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			first := run(t, name, 42, name, 100)
+			second := run(t, name, 42, name, 100)
+			if !bytes.Equal(first, second) {
+				t.Errorf("two runs with seed 42 differ:\n%s\n%s", first, second)
+			}
+
+			if other := run(t, name, 43, name, 100); bytes.Equal(first, other) {
+				t.Errorf("seeds 42 and 43 produced the same entries")
+			}
+			// A second generator of the same kind draws from its own stream
+			if other := run(t, name, 42, name+"#2", 100); bytes.Equal(first, other) {
+				t.Errorf("streams %s and %s#2 produced the same entries", name, name)
+			}
+		})
+	}
+}
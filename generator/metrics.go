@@ -0,0 +1,42 @@
+package generator
+
+import (
+	"fmt"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("metrics", func(src Source) Generator { return metricsGenerator{src} })
+}
+
+// metricsGenerator produces system resource usage logs
+type metricsGenerator struct{ Source }
+
+func (metricsGenerator) Name() string { return "metrics" }
+
+func (g metricsGenerator) Generate(n int) []logentry.LogEntry {
+	logs := make([]logentry.LogEntry, n)
+	for i := range logs {
+		cpuUsage := g.Rand.Float64() * 100
+		memoryUsage := g.Rand.Float64() * 100
+		diskUsage := g.Rand.Float64() * 100
+
+		logs[i] = logentry.LogEntry{
+			Timestamp:   g.Now().Format(time.RFC3339),
+			Level:       "INFO",
+			Service:     "system-metrics",
+			Message:     fmt.Sprintf("System metrics: CPU: %.2f%%, Memory: %.2f%%, Disk: %.2f%%", cpuUsage, memoryUsage, diskUsage),
+			Environment: pick(g.Rand, environments),
+			Generator:   "metrics",
+			Metadata: map[string]interface{}{
+				"cpu":    cpuUsage,
+				"memory": memoryUsage,
+				"disk":   diskUsage,
+				"host":   fmt.Sprintf("server-%d", g.Rand.Intn(10)+1),
+			},
+		}
+	}
+	return logs
+}
@@ -0,0 +1,363 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Profile shapes the generation rate over time for capacity tests. It is a
+// list of stages run one after the other, each multiplying the configured
+// rate by a factor that follows its shape:
+//
+//	constant  level throughout (default 1)
+//	ramp      linear from from to to (default 0 to 1)
+//	step      from to to in steps equal stairs (default 0.25 to 1 in 4)
+//	spike     level, with bursts at peak for length every every, or once at
+//	          the start without every (default 1, 5 and 1s)
+//	sine      between min and max with the given period, starting halfway
+//	          and rising (default 0 to 1 over the stage duration)
+//	diurnal   between min and max over a day, at max at peak_at and at min
+//	          twelve hours later; period compresses the day and start_at is
+//	          the time of day the stage begins at (default 0.1 to 1, 24h,
+//	          14:00 and 00:00)
+//
+// After the last stage the profile starts over when Repeat is set, and
+// otherwise holds the factor the last stage ended on.
+type Profile struct {
+	Name   string  `json:"name"`
+	Repeat bool    `json:"repeat"`
+	Stages []Stage `json:"stages"`
+}
+
+// Profile shapes
+const (
+	ShapeConstant = "constant"
+	ShapeRamp     = "ramp"
+	ShapeStep     = "step"
+	ShapeSpike    = "spike"
+	ShapeSine     = "sine"
+	ShapeDiurnal  = "diurnal"
+)
+
+// Stage is one part of a Profile. Each shape uses only some of the fields,
+// see Profile.
+type Stage struct {
+	Shape    string
+	Duration time.Duration
+	From, To float64
+	Steps    int
+	Level    float64
+	Peak     float64
+	Every    time.Duration
+	Length   time.Duration
+	Min, Max float64
+	Period   time.Duration
+	PeakAt   time.Duration
+	StartAt  time.Duration
+}
+
+// stageJSON is a Stage as written in a profile file, with durations such as
+// 5m and times of day such as 14:00
+type stageJSON struct {
+	Shape    string  `json:"shape"`
+	Duration string  `json:"duration"`
+	From     float64 `json:"from"`
+	To       float64 `json:"to"`
+	Steps    int     `json:"steps"`
+	Level    float64 `json:"level"`
+	Peak     float64 `json:"peak"`
+	Every    string  `json:"every"`
+	Length   string  `json:"length"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+	Period   string  `json:"period"`
+	PeakAt   string  `json:"peak_at"`
+	StartAt  string  `json:"start_at"`
+}
+
+// stageDefaults holds the defaults of every shape, which also makes it the
+// list of known shapes
+var stageDefaults = map[string]stageJSON{
+	ShapeConstant: {Level: 1},
+	ShapeRamp:     {From: 0, To: 1},
+	ShapeStep:     {From: 0.25, To: 1, Steps: 4},
+	ShapeSpike:    {Level: 1, Peak: 5, Length: "1s"},
+	ShapeSine:     {Min: 0, Max: 1},
+	ShapeDiurnal:  {Min: 0.1, Max: 1, Period: "24h", PeakAt: "14:00", StartAt: "00:00"},
+}
+
+func (s *Stage) UnmarshalJSON(data []byte) error {
+	var head struct {
+		Shape string `json:"shape"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	raw, ok := stageDefaults[head.Shape]
+	if !ok {
+		return fmt.Errorf("unknown shape %q (available: constant, ramp, step, spike, sine, diurnal)", head.Shape)
+	}
+	if err := decodeStrict(data, &raw); err != nil {
+		return fmt.Errorf("%s stage: %w", head.Shape, err)
+	}
+
+	stage := Stage{
+		Shape: raw.Shape, From: raw.From, To: raw.To, Steps: raw.Steps,
+		Level: raw.Level, Peak: raw.Peak, Min: raw.Min, Max: raw.Max,
+	}
+	var err error
+	if stage.Duration, err = parseDuration(raw.Shape, "duration", raw.Duration); err != nil {
+		return err
+	}
+	if stage.Every, err = parseDuration(raw.Shape, "every", raw.Every); err != nil {
+		return err
+	}
+	if stage.Length, err = parseDuration(raw.Shape, "length", raw.Length); err != nil {
+		return err
+	}
+	if stage.Period, err = parseDuration(raw.Shape, "period", raw.Period); err != nil {
+		return err
+	}
+	if stage.PeakAt, err = parseTimeOfDay(raw.Shape, "peak_at", raw.PeakAt); err != nil {
+		return err
+	}
+	if stage.StartAt, err = parseTimeOfDay(raw.Shape, "start_at", raw.StartAt); err != nil {
+		return err
+	}
+	if stage.Shape == ShapeSine && stage.Period == 0 {
+		stage.Period = stage.Duration
+	}
+	if err := stage.validate(); err != nil {
+		return err
+	}
+	*s = stage
+	return nil
+}
+
+func parseDuration(shape, key, val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s stage: %s must be a duration such as 30s or 5m, got %q", shape, key, val)
+	}
+	return d, nil
+}
+
+func parseTimeOfDay(shape, key, val string) (time.Duration, error) {
+	if val == "" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", val)
+	if err != nil {
+		return 0, fmt.Errorf("%s stage: %s must be a time of day such as 14:00, got %q", shape, key, val)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (s Stage) validate() error {
+	if s.Duration <= 0 {
+		return fmt.Errorf("%s stage: duration is required", s.Shape)
+	}
+	for _, f := range []float64{s.From, s.To, s.Level, s.Peak, s.Min, s.Max} {
+		if f < 0 {
+			return fmt.Errorf("%s stage: factors must not be negative", s.Shape)
+		}
+	}
+	switch s.Shape {
+	case ShapeStep:
+		if s.Steps < 1 {
+			return fmt.Errorf("step stage: steps must be at least 1")
+		}
+	case ShapeSine, ShapeDiurnal:
+		if s.Min > s.Max {
+			return fmt.Errorf("%s stage: min must not be above max", s.Shape)
+		}
+		if s.Period <= 0 {
+			return fmt.Errorf("%s stage: period must be positive", s.Shape)
+		}
+	}
+	return nil
+}
+
+// Factor returns the factor t into the stage
+func (s Stage) Factor(t time.Duration) float64 {
+	switch s.Shape {
+	case ShapeRamp:
+		return s.From + (s.To-s.From)*min(t.Seconds()/s.Duration.Seconds(), 1)
+	case ShapeStep:
+		if s.Steps == 1 {
+			return s.To
+		}
+		stair := min(int(float64(s.Steps)*t.Seconds()/s.Duration.Seconds()), s.Steps-1)
+		return s.From + (s.To-s.From)*float64(stair)/float64(s.Steps-1)
+	case ShapeSpike:
+		if s.Every > 0 {
+			t %= s.Every
+		}
+		if t < s.Length {
+			return s.Peak
+		}
+		return s.Level
+	case ShapeSine:
+		mid, amplitude := (s.Max+s.Min)/2, (s.Max-s.Min)/2
+		return mid + amplitude*math.Sin(2*math.Pi*t.Seconds()/s.Period.Seconds())
+	case ShapeDiurnal:
+		const day = 24 * time.Hour
+		clock := s.StartAt + time.Duration(float64(t)*float64(day)/float64(s.Period))
+		phase := 2 * math.Pi * (clock - s.PeakAt).Seconds() / day.Seconds()
+		return s.Min + (s.Max-s.Min)*(1+math.Cos(phase))/2
+	}
+	return s.Level
+}
+
+// ParseProfile parses a profile in JSON, e.g.
+//
+//	{"name": "soak", "stages": [
+//	  {"shape": "ramp", "duration": "5m", "to": 1},
+//	  {"shape": "spike", "duration": "10m", "every": "1m", "length": "5s"}
+//	]}
+func ParseProfile(data []byte) (*Profile, error) {
+	var p Profile
+	if err := decodeStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("load profile: %w", err)
+	}
+	if len(p.Stages) == 0 {
+		return nil, fmt.Errorf("load profile has no stages")
+	}
+	return &p, nil
+}
+
+// decodeStrict decodes data into v, rejecting keys v has no field for so
+// that a typo such as "form" fails instead of falling back to a default
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// LoadProfile reads a profile file, see ParseProfile
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParseProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+// Length returns the duration of one pass through the stages
+func (p *Profile) Length() time.Duration {
+	var length time.Duration
+	for _, stage := range p.Stages {
+		length += stage.Duration
+	}
+	return length
+}
+
+// At returns the index of the stage elapsed into the profile, and its
+// factor. done is set once a profile without Repeat has run through.
+func (p *Profile) At(elapsed time.Duration) (stage int, factor float64, done bool) {
+	if p.Repeat {
+		elapsed %= p.Length()
+	}
+	for i, s := range p.Stages {
+		if elapsed < s.Duration {
+			return i, s.Factor(elapsed), false
+		}
+		elapsed -= s.Duration
+	}
+	last := p.Stages[len(p.Stages)-1]
+	return len(p.Stages) - 1, last.Factor(last.Duration), true
+}
+
+// Start begins a run through the profile. It returns nil for a nil
+// profile.
+func (p *Profile) Start() *ProfileRun {
+	if p == nil {
+		return nil
+	}
+	return &ProfileRun{profile: p, start: time.Now()}
+}
+
+// ProfileRun follows a Profile from the time it was started. A nil
+// *ProfileRun keeps the factor at 1.
+type ProfileRun struct {
+	profile *Profile
+	start   time.Time
+}
+
+// ProfileState is where a ProfileRun is
+type ProfileState struct {
+	Name string `json:"name"`
+	// Stage counts from 1
+	Stage  int     `json:"stage"`
+	Stages int     `json:"stages"`
+	Shape  string  `json:"shape"`
+	Factor float64 `json:"factor"`
+	// Elapsed is the time into the current pass through the stages
+	Elapsed float64 `json:"elapsed_s"`
+	Length  float64 `json:"length_s"`
+	Repeat  bool    `json:"repeat"`
+	Done    bool    `json:"done"`
+}
+
+func (s ProfileState) String() string {
+	state := fmt.Sprintf("%s stage %d/%d (%s) factor=%.2f at %s of %s", s.Name, s.Stage, s.Stages, s.Shape, s.Factor,
+		time.Duration(s.Elapsed*float64(time.Second)).Round(time.Second),
+		time.Duration(s.Length*float64(time.Second)).Round(time.Second))
+	if s.Done {
+		state += ", done"
+	}
+	return state
+}
+
+// Scale returns the current factor. It has the signature of Loop.Scale.
+func (r *ProfileRun) Scale() float64 {
+	if r == nil {
+		return 1
+	}
+	return r.State().Factor
+}
+
+// State returns the current stage and factor
+func (r *ProfileRun) State() ProfileState {
+	if r == nil {
+		return ProfileState{Factor: 1}
+	}
+	elapsed := time.Since(r.start)
+	stage, factor, done := r.profile.At(elapsed)
+	if r.profile.Repeat {
+		elapsed %= r.profile.Length()
+	}
+	return ProfileState{
+		Name:    r.profile.Name,
+		Stage:   stage + 1,
+		Stages:  len(r.profile.Stages),
+		Shape:   r.profile.Stages[stage].Shape,
+		Factor:  factor,
+		Elapsed: elapsed.Seconds(),
+		Length:  r.profile.Length().Seconds(),
+		Repeat:  r.profile.Repeat,
+		Done:    done,
+	}
+}
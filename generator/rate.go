@@ -0,0 +1,154 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// Target is a throughput in entries or in bytes of JSON encoded entries per
+// second. At most one of the two is set.
+type Target struct {
+	Logs  float64
+	Bytes float64
+}
+
+// IsZero reports whether no target is set
+func (t Target) IsZero() bool { return t.Logs == 0 && t.Bytes == 0 }
+
+// Scale returns the target multiplied by f
+func (t Target) Scale(f float64) Target { return Target{Logs: t.Logs * f, Bytes: t.Bytes * f} }
+
+func (t Target) String() string {
+	if t.Bytes > 0 {
+		return formatBytes(t.Bytes) + "/s"
+	}
+	return fmt.Sprintf("%.1f logs/s", t.Logs)
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   float64
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// ParseTarget parses a rate such as 200/s, 30/m or 2MB/s. A number with a
+// B, KB, MB or GB suffix is a byte rate, a plain number counts entries. The
+// unit of time is s, m or h, and a plain number is per second.
+func ParseTarget(s string) (Target, error) {
+	num, unit, _ := strings.Cut(strings.TrimSpace(s), "/")
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Target{}, fmt.Errorf("rate %q must be per s, m or h", s)
+	}
+	size := 0.0
+	upper := strings.ToUpper(num)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			num, size = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n <= 0 {
+		return Target{}, fmt.Errorf("rate %q must be a positive number of entries or bytes", s)
+	}
+	if size > 0 {
+		return Target{Bytes: n * size / per.Seconds()}, nil
+	}
+	return Target{Logs: n / per.Seconds()}, nil
+}
+
+// formatBytes formats a byte count with the largest unit that keeps it at
+// one or more
+func formatBytes(n float64) string {
+	for _, u := range byteUnits {
+		if n >= u.size || u.size == 1 {
+			return strconv.FormatFloat(n/u.size, 'f', 1, 64) + " " + u.suffix
+		}
+	}
+	return ""
+}
+
+// Meter counts the entries and bytes loops produce, so that callers can
+// compare the achieved rate with the target. Bytes are only counted by loops
+// with a byte target.
+type Meter struct {
+	entries atomic.Int64
+	bytes   atomic.Int64
+
+	mu  sync.Mutex
+	due float64
+}
+
+func (m *Meter) add(entries, bytes int) {
+	if m == nil {
+		return
+	}
+	m.entries.Add(int64(entries))
+	m.bytes.Add(int64(bytes))
+}
+
+// Totals returns the entries and bytes produced so far
+func (m *Meter) Totals() (entries, bytes int64) {
+	return m.entries.Load(), m.bytes.Load()
+}
+
+func (m *Meter) addDue(n float64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.due += n
+	m.mu.Unlock()
+}
+
+// Due returns the entries or bytes an open-loop run should have produced so
+// far, in the unit of its target. Unlike Target it follows Loop.Scale, so it
+// stays accurate while the rate changes.
+func (m *Meter) Due() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.due
+}
+
+// Value returns the target in its own unit, entries or bytes per second
+func (t Target) Value() float64 {
+	if t.Bytes > 0 {
+		return t.Bytes
+	}
+	return t.Logs
+}
+
+// Achieved returns the throughput of entries and bytes produced over d, in
+// the unit of the target
+func (t Target) Achieved(entries, bytes int64, d time.Duration) float64 {
+	if t.Bytes > 0 {
+		return float64(bytes) / d.Seconds()
+	}
+	return float64(entries) / d.Seconds()
+}
+
+// encodedSize returns the size of logs as JSON, the measure byte targets
+// are given in
+func encodedSize(logs []logentry.LogEntry) int {
+	size := 0
+	for _, entry := range logs {
+		raw, err := json.Marshal(entry)
+		if err == nil {
+			size += len(raw)
+		}
+	}
+	return size
+}
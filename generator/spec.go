@@ -0,0 +1,74 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Spec selects a generator and how fast it runs, as given on the command
+// line in the form name[=rate][,batch=N], e.g. api=200/s,batch=20
+type Spec struct {
+	Name string
+	// Target is the rate, see ParseTarget. Zero keeps the caller's
+	// default.
+	Target Target
+	// BatchSize is the number of entries per batch. Zero keeps the
+	// caller's default.
+	BatchSize int
+}
+
+// ParseSpec parses name[=rate][,batch=N], with the rate in the form of
+// ParseTarget
+func ParseSpec(s string) (Spec, error) {
+	head, rest, _ := strings.Cut(strings.TrimSpace(s), ",")
+	name, rate, hasRate := strings.Cut(head, "=")
+	spec := Spec{Name: strings.TrimSpace(name)}
+	if spec.Name == "" {
+		return spec, fmt.Errorf("generator spec %q has no name", s)
+	}
+	if _, err := lookup(spec.Name); err != nil {
+		return spec, err
+	}
+	if hasRate {
+		var err error
+		if spec.Target, err = ParseTarget(rate); err != nil {
+			return spec, fmt.Errorf("generator spec %q: %w", s, err)
+		}
+	}
+	for _, opt := range strings.Split(rest, ",") {
+		if opt = strings.TrimSpace(opt); opt == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(opt, "=")
+		if key != "batch" {
+			return spec, fmt.Errorf("generator spec %q: unknown option %q", s, key)
+		}
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 {
+			return spec, fmt.Errorf("generator spec %q: batch must be a positive number, got %q", s, val)
+		}
+		spec.BatchSize = n
+	}
+	return spec, nil
+}
+
+// Specs collects repeated --gen flags
+type Specs []Spec
+
+func (s *Specs) String() string {
+	parts := make([]string, len(*s))
+	for i, spec := range *s {
+		parts[i] = spec.Name
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *Specs) Set(v string) error {
+	spec, err := ParseSpec(v)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, spec)
+	return nil
+}
@@ -0,0 +1,42 @@
+package generator
+
+import (
+	"fmt"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("user", func(src Source) Generator { return userGenerator{src} })
+}
+
+// userGenerator produces user activity logs
+type userGenerator struct{ Source }
+
+func (userGenerator) Name() string { return "user" }
+
+func (g userGenerator) Generate(n int) []logentry.LogEntry {
+	logs := make([]logentry.LogEntry, n)
+	for i := range logs {
+		userID := pick(g.Rand, userIDs)
+		action := pick(g.Rand, userActions)
+
+		logs[i] = logentry.LogEntry{
+			Timestamp:   g.Now().Format(time.RFC3339),
+			Level:       "INFO",
+			Service:     "user-activity-service",
+			Message:     fmt.Sprintf("User %s performed action: %s", userID, action),
+			UserID:      userID,
+			Action:      action,
+			Environment: pick(g.Rand, environments),
+			Generator:   "user",
+			Metadata: map[string]interface{}{
+				"browser":  pick(g.Rand, browsers),
+				"platform": pick(g.Rand, platforms),
+				"ip":       fmt.Sprintf("192.168.%d.%d", g.Rand.Intn(255), g.Rand.Intn(255)),
+			},
+		}
+	}
+	return logs
+}
@@ -2,4 +2,13 @@ module log-generator
 
 go 1.24.0
 
-require github.com/gorilla/websocket v1.5.3
+require (
+	github.com/golang/snappy v1.0.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.15.9
+	github.com/segmentio/kafka-go v0.4.51
+	golang.org/x/net v0.38.0
+	google.golang.org/protobuf v1.36.12
+)
+
+require github.com/pierrec/lz4/v4 v4.1.15 // indirect
@@ -0,0 +1,23 @@
+// Package logentry defines the record shape shared by the generators and
+// every sink.
+package logentry
+
+// LogEntry represents a single log entry
+type LogEntry struct {
+	Timestamp   string      `json:"timestamp"`
+	Level       string      `json:"level"`
+	Service     string      `json:"service"`
+	Message     string      `json:"message"`
+	StatusCode  int         `json:"status_code,omitempty"`
+	Method      string      `json:"method,omitempty"`
+	Path        string      `json:"path,omitempty"`
+	Duration    int         `json:"duration,omitempty"`
+	UserID      string      `json:"user_id,omitempty"`
+	Action      string      `json:"action,omitempty"`
+	Metadata    interface{} `json:"metadata,omitempty"`
+	Environment string      `json:"environment"`
+	// Generator names the generator that produced the entry (api, db, user
+	// or metrics). It is not part of the JSON document; sinks use it for
+	// routing, e.g. as part of a Fluent tag.
+	Generator string `json:"-"`
+}
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	stdlog "log"
+	"strings"
 	"sync"
+	"time"
+
+	"log-generator/generator"
+	"log-generator/sink"
 )
 
 // Configuration constants
@@ -16,22 +25,75 @@ var (
 	isRunning bool
 	// Mutex for controlling isRunning access
 	runningMux sync.Mutex
+	// Load profile of the current run, nil without one
+	profileRun *generator.ProfileRun
 )
 
 func main() {
+	var sinkSpecs sink.Specs
+	flag.Var(&sinkSpecs, "sink", "Log destination as name?key=value&..., repeat to send to several (default: EasyLogs). Available: "+strings.Join(sink.Names(), ", "))
+	adaptiveOptions := flag.String("adaptive", "", "Scale the generation rate down while the sinks struggle: on, or options as key=value&...")
+	profilePath := flag.String("profile", "", "Load profile file that shapes the generation rate of every run over time")
+	seedFlag := flag.Int64("seed", 0, "Seed of the generators' random streams, the same for every run (default: random per run, logged at start)")
+	flag.Parse()
+
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seed = seedFlag
+		}
+	})
+
+	var err error
+	if *profilePath != "" {
+		if defaultProfile, err = generator.LoadProfile(*profilePath); err != nil {
+			stdlog.Fatalf("Error loading profile: %s", err)
+		}
+	}
+	if *adaptiveOptions != "" {
+		if adaptive, err = sink.ParseAdaptive(*adaptiveOptions); err != nil {
+			stdlog.Fatalf("Error configuring adaptive rate: %s", err)
+		}
+	}
+	logSink, err = openSink(sinkSpecs)
+	if err != nil {
+		stdlog.Fatalf("Error configuring sink: %s", err)
+	}
+	defer logSink.Close()
+	fmt.Printf("Sending logs to sink: %s\n", sinkNames(sinkSpecs))
+
 	// Start the web server
 	startWebServer()
 }
 
-// Function to stop log generation
-func stopLogGeneration() {
+// sinkNames returns the sink names of specs for display, leaving out options
+// that may carry credentials
+func sinkNames(specs []string) string {
+	if len(specs) == 0 {
+		return "easylogs"
+	}
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i], _, _ = strings.Cut(spec, "?")
+	}
+	return strings.Join(names, ", ")
+}
+
+// stopLogGeneration stops the generators and flushes the sinks. It reports
+// false, without flushing, if log generation was not running.
+func stopLogGeneration(ctx context.Context) bool {
 	runningMux.Lock()
-	defer runningMux.Unlock()
-	
-	if isRunning {
-		close(stopChan)
-		isRunning = false
-		// Create new channel for next start
-		stopChan = make(chan struct{})
+	if !isRunning {
+		runningMux.Unlock()
+		return false
 	}
-} 
\ No newline at end of file
+	close(stopChan)
+	isRunning = false
+	runningMux.Unlock()
+
+	// Wait a bit longer to ensure all goroutines have stopped
+	time.Sleep(500 * time.Millisecond)
+	if err := logSink.Flush(ctx); err != nil {
+		stdlog.Printf("Error flushing sink: %s", err)
+	}
+	return true
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	"io/ioutil"
+	"context"
 	stdlog "log"
-	"net/http"
 	"time"
+
+	"log-generator/logentry"
+	"log-generator/sink"
 )
 
 const (
@@ -25,59 +25,46 @@ const (
 )
 
 // LogEntry represents a single log entry
-type LogEntry struct {
-	Timestamp   string      `json:"timestamp"`
-	Level       string      `json:"level"`
-	Service     string      `json:"service"`
-	Message     string      `json:"message"`
-	StatusCode  int         `json:"status_code,omitempty"`
-	Method      string      `json:"method,omitempty"`
-	Path        string      `json:"path,omitempty"`
-	Duration    int         `json:"duration,omitempty"`
-	UserID      string      `json:"user_id,omitempty"`
-	Action      string      `json:"action,omitempty"`
-	Metadata    interface{} `json:"metadata,omitempty"`
-	Environment string      `json:"environment"`
-}
+type LogEntry = logentry.LogEntry
 
-func bulkIndexLogs(logs []LogEntry) {
-	// Create a buffer for the JSON array of logs
-	var buf bytes.Buffer
-	
-	// Encode the entire logs array as a single JSON array
-	if err := json.NewEncoder(&buf).Encode(logs); err != nil {
-		stdlog.Printf("Error encoding log entries: %s", err)
-		return
+// logSink receives every batch produced by the generators and queues it for
+// each destination
+var logSink *sink.FanOut
+
+// adaptive scales the generation rate with how the sinks cope. It is nil
+// unless --adaptive is given.
+var adaptive *sink.AdaptiveRate
+
+// openSink builds the sinks described by specs, falling back to EasyLogs with
+// the configuration above when there are none. Every destination gets its
+// own queue and senders, so the generator loops never wait for a response.
+func openSink(specs []string) (*sink.FanOut, error) {
+	fanOut := &sink.FanOut{}
+	if len(specs) == 0 {
+		s, err := sink.New("easylogs", sink.Config{"url": elasticHost, "auth": authHeader})
+		if err != nil {
+			return nil, err
+		}
+		fanOut.Add("easylogs", s, sink.DefaultQueueSize, 1, false)
+	} else {
+		var err error
+		if fanOut, err = sink.OpenFanOut(specs); err != nil {
+			return nil, err
+		}
 	}
-	
-	// Create HTTP request to EasyLogs
-	req, err := http.NewRequest("POST", elasticHost, &buf)
-	if err != nil {
-		stdlog.Printf("Error creating request: %s", err)
-		return
+	fanOut.OnError = func(target string, err error) {
+		stdlog.Printf("Error sending logs to %s: %s", target, err)
 	}
-	
-	// Set required headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authHeader)
-	
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if adaptive != nil {
+		fanOut.OnResult = func(target string, took time.Duration, err error) {
+			adaptive.Observe(took, err)
+		}
 	}
-	
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
+	return fanOut, nil
+}
+
+func bulkIndexLogs(logs []LogEntry) {
+	if err := logSink.Send(context.Background(), logs); err != nil {
 		stdlog.Printf("Error sending logs: %s", err)
-		return
-	}
-	defer resp.Body.Close()
-	
-	if resp.StatusCode >= 400 {
-		stdlog.Printf("Error from API. Status: %d", resp.StatusCode)
-		// Read and log response body for debugging
-		body, _ := ioutil.ReadAll(resp.Body)
-		stdlog.Printf("Response: %s", string(body))
 	}
-} 
\ No newline at end of file
+}
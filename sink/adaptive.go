@@ -0,0 +1,160 @@
+package sink
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// AdaptiveRate scales the generation rate down when the sinks cannot keep
+// up and back up once they recover, additive increase, multiplicative
+// decrease (AIMD) style. Every interval it looks at the sends observed: if
+// their mean latency exceeded the target or too many of them failed, the
+// rate is multiplied by decrease, otherwise it grows by increase, between
+// min_rate and 1.
+//
+// A nil *AdaptiveRate leaves the rate at 1.
+type AdaptiveRate struct {
+	latency   time.Duration
+	errorRate float64
+	minRate   float64
+	increase  float64
+	decrease  float64
+	interval  time.Duration
+
+	mu          sync.Mutex
+	rate        float64
+	windowStart time.Time
+	sends       int
+	failures    int
+	total       time.Duration
+	last        AdaptiveState
+}
+
+// AdaptiveState is a snapshot of an AdaptiveRate
+type AdaptiveState struct {
+	// Rate is the fraction of the configured generation rate in use
+	Rate float64 `json:"rate"`
+	// LatencyMS and ErrorRate describe the last full interval
+	LatencyMS float64 `json:"latency_ms"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func (s AdaptiveState) String() string {
+	return fmt.Sprintf("rate=%.0f%% latency=%.0fms errors=%.1f%%", s.Rate*100, s.LatencyMS, s.ErrorRate*100)
+}
+
+// ParseAdaptive builds an AdaptiveRate from options given as
+// key=value&key=value, or on for the defaults:
+//
+//	latency     mean send latency above which the rate decreases (default 1s)
+//	error_rate  fraction of failed sends above which the rate decreases (default 0.1)
+//	min_rate    lowest fraction of the configured rate (default 0.05)
+//	increase    fraction added after a good interval (default 0.05)
+//	decrease    factor applied after a bad interval (default 0.5)
+//	interval    how often the rate is adjusted (default 1s)
+func ParseAdaptive(options string) (*AdaptiveRate, error) {
+	cfg := Config{}
+	if options != "on" {
+		values, err := url.ParseQuery(options)
+		if err != nil {
+			return nil, fmt.Errorf("adaptive options %q: %w", options, err)
+		}
+		for key, vals := range values {
+			cfg[key] = vals[len(vals)-1]
+		}
+	}
+	a := &AdaptiveRate{rate: 1, windowStart: time.Now()}
+	var err error
+	if a.latency, err = cfg.Duration("latency", time.Second); err != nil {
+		return nil, err
+	}
+	if a.errorRate, err = cfg.Float("error_rate", 0.1); err != nil {
+		return nil, err
+	}
+	if a.minRate, err = cfg.Float("min_rate", 0.05); err != nil {
+		return nil, err
+	}
+	if a.increase, err = cfg.Float("increase", 0.05); err != nil {
+		return nil, err
+	}
+	if a.decrease, err = cfg.Float("decrease", 0.5); err != nil {
+		return nil, err
+	}
+	if a.interval, err = cfg.Duration("interval", time.Second); err != nil {
+		return nil, err
+	}
+	if a.minRate <= 0 || a.minRate > 1 {
+		return nil, fmt.Errorf("option min_rate must be above 0 and at most 1")
+	}
+	if a.decrease <= 0 || a.decrease >= 1 {
+		return nil, fmt.Errorf("option decrease must be between 0 and 1")
+	}
+	if a.interval <= 0 {
+		return nil, fmt.Errorf("option interval must be positive")
+	}
+	a.last.Rate = 1
+	return a, nil
+}
+
+// Observe records the outcome of one send. It has the signature of
+// Pool.OnResult.
+func (a *AdaptiveRate) Observe(took time.Duration, err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.adjust()
+	a.sends++
+	a.total += took
+	if err != nil {
+		a.failures++
+	}
+}
+
+// adjust closes the current interval once it is over. The caller holds mu.
+func (a *AdaptiveRate) adjust() {
+	if time.Since(a.windowStart) < a.interval {
+		return
+	}
+	a.windowStart = time.Now()
+	if a.sends == 0 {
+		// Nothing to judge by, keep the rate
+		a.last = AdaptiveState{Rate: a.rate}
+		return
+	}
+	mean := a.total / time.Duration(a.sends)
+	errorRate := float64(a.failures) / float64(a.sends)
+	if mean > a.latency || errorRate > a.errorRate {
+		a.rate *= a.decrease
+	} else {
+		a.rate += a.increase
+	}
+	a.rate = min(max(a.rate, a.minRate), 1)
+	a.last = AdaptiveState{
+		Rate:      a.rate,
+		LatencyMS: float64(mean) / float64(time.Millisecond),
+		ErrorRate: errorRate,
+	}
+	a.sends, a.failures, a.total = 0, 0, 0
+}
+
+// Rate returns the fraction of the configured generation rate to produce.
+// It has the signature of generator.Loop.Scale.
+func (a *AdaptiveRate) Rate() float64 {
+	return a.State().Rate
+}
+
+// State returns the current rate and the measurements behind it
+func (a *AdaptiveRate) State() AdaptiveState {
+	if a == nil {
+		return AdaptiveState{Rate: 1}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.adjust()
+	return a.last
+}
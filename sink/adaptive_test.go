@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRate(t *testing.T) {
+	a, err := ParseAdaptive("latency=100ms&error_rate=0.2&min_rate=0.1&increase=0.25&decrease=0.5&interval=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// endInterval makes the current interval end now
+	endInterval := func() {
+		a.mu.Lock()
+		a.windowStart = time.Now().Add(-a.interval)
+		a.mu.Unlock()
+	}
+	step := func(took time.Duration, failed, sends int) float64 {
+		for i := 0; i < sends; i++ {
+			var err error
+			if i < failed {
+				err = errors.New("failed")
+			}
+			a.Observe(took, err)
+		}
+		endInterval()
+		return a.Rate()
+	}
+
+	if got := a.Rate(); got != 1 {
+		t.Fatalf("initial rate %v, want 1", got)
+	}
+	for _, s := range []struct {
+		name   string
+		took   time.Duration
+		failed int
+		sends  int
+		want   float64
+	}{
+		{"slow sends halve the rate", 200 * time.Millisecond, 0, 10, 0.5},
+		{"too many failures halve it", 10 * time.Millisecond, 3, 10, 0.25},
+		{"it does not fall below min_rate", time.Second, 0, 10, 0.125},
+		{"", time.Second, 0, 10, 0.1},
+		{"", time.Second, 10, 10, 0.1},
+		{"an interval without sends keeps it", 0, 0, 0, 0.1},
+		{"good intervals raise it additively", 10 * time.Millisecond, 2, 10, 0.35},
+		{"", 10 * time.Millisecond, 0, 10, 0.6},
+		{"", 10 * time.Millisecond, 0, 10, 0.85},
+		{"it does not rise above 1", 10 * time.Millisecond, 0, 10, 1},
+		{"", 10 * time.Millisecond, 0, 10, 1},
+	} {
+		if got := step(s.took, s.failed, s.sends); got < s.want-1e-9 || got > s.want+1e-9 {
+			t.Fatalf("%s: rate %v, want %v", s.name, got, s.want)
+		}
+	}
+
+	state := a.State()
+	if state.LatencyMS != 10 || state.ErrorRate != 0 {
+		t.Errorf("state %+v, want the latency and error rate of the last interval", state)
+	}
+}
+
+func TestAdaptiveRateNil(t *testing.T) {
+	var a *AdaptiveRate
+	a.Observe(time.Second, errors.New("failed"))
+	if got := a.Rate(); got != 1 {
+		t.Errorf("nil AdaptiveRate rate %v, want 1", got)
+	}
+}
@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// batchOptions are the batching settings accepted by every sink spec
+var batchOptions = []string{"batch_max_entries", "batch_max_bytes", "batch_linger"}
+
+// batcher regroups the batches handed to Send into batches bounded by entry
+// count, encoded size and age, independent of how the generators produce
+// them
+type batcher struct {
+	inner      Sink
+	maxEntries int
+	maxBytes   int64
+	linger     time.Duration
+
+	// mu is held while a batch is sent, so batches leave in order
+	mu       sync.Mutex
+	buf      []logentry.LogEntry
+	bufBytes int64
+	firstAt  time.Time
+	timer    *time.Timer
+	closed   bool
+}
+
+// takeBatch removes the batching options from cfg. It returns nil settings
+// when the spec sets none of them:
+//
+//	batch_max_entries  send once this many entries are buffered (default 1000)
+//	batch_max_bytes    send before the JSON encoded entries would exceed this, e.g. 5MB (default 5MB)
+//	batch_linger       send a partial batch once its oldest entry is this old (default 1s)
+func takeBatch(cfg Config) Config {
+	var opts Config
+	for _, key := range batchOptions {
+		if v, ok := cfg[key]; ok {
+			if opts == nil {
+				opts = make(Config)
+			}
+			opts[key] = v
+			delete(cfg, key)
+		}
+	}
+	return opts
+}
+
+// newBatcher wraps inner in a batcher configured by the options returned
+// from takeBatch
+func newBatcher(inner Sink, opts Config) (*batcher, error) {
+	b := &batcher{inner: inner}
+	var err error
+	if b.maxEntries, err = opts.Int("batch_max_entries", 1000); err != nil {
+		return nil, err
+	}
+	if b.maxBytes, err = opts.Size("batch_max_bytes", 5<<20); err != nil {
+		return nil, err
+	}
+	if b.linger, err = opts.Duration("batch_linger", time.Second); err != nil {
+		return nil, err
+	}
+	if b.maxEntries < 1 {
+		return nil, fmt.Errorf("option batch_max_entries must be at least 1")
+	}
+	if b.linger <= 0 {
+		return nil, fmt.Errorf("option batch_linger must be positive")
+	}
+	return b, nil
+}
+
+// Send buffers logs and sends every batch that fills up on the way. An entry
+// larger than batch_max_bytes is sent on its own.
+func (b *batcher) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("batcher is closed")
+	}
+	var firstErr error
+	for _, entry := range logs {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("encoding log entry: %w", err)
+		}
+		size := int64(len(raw))
+		if len(b.buf) > 0 && b.maxBytes > 0 && b.bufBytes+size > b.maxBytes {
+			if err := b.sendLocked(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if len(b.buf) == 0 {
+			b.firstAt = time.Now()
+			b.startTimer()
+		}
+		b.buf = append(b.buf, entry)
+		b.bufBytes += size
+		if len(b.buf) >= b.maxEntries {
+			if err := b.sendLocked(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// startTimer arranges for the batch started now to be sent after linger.
+// The caller holds mu.
+func (b *batcher) startTimer() {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.linger, b.lingerExpired)
+		return
+	}
+	b.timer.Reset(b.linger)
+}
+
+// lingerExpired sends the buffered batch if it is old enough. A timer that
+// fires for a batch already sent finds a younger batch or none and leaves
+// it alone.
+func (b *batcher) lingerExpired() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.buf) == 0 || b.closed {
+		return
+	}
+	if wait := b.linger - time.Since(b.firstAt); wait > 0 {
+		b.timer.Reset(wait)
+		return
+	}
+	// Failures are counted by the wrapped sink
+	b.sendLocked(context.Background())
+}
+
+// sendLocked hands the buffered entries to the wrapped sink. The caller
+// holds mu.
+func (b *batcher) sendLocked(ctx context.Context) error {
+	if len(b.buf) == 0 {
+		return nil
+	}
+	logs := b.buf
+	b.buf = nil
+	b.bufBytes = 0
+	return b.inner.Send(ctx, logs)
+}
+
+// Flush sends the partial batch, then flushes the wrapped sink
+func (b *batcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	err := b.sendLocked(ctx)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return b.inner.Flush(ctx)
+}
+
+// Close sends the partial batch and closes the wrapped sink
+func (b *batcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.sendLocked(context.Background())
+	b.mu.Unlock()
+
+	if closeErr := b.inner.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Stats reports the counters of the wrapped sink
+func (b *batcher) Stats() Stats {
+	return b.inner.Stats()
+}
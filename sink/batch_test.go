@@ -0,0 +1,124 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func openBatcher(t *testing.T, inner Sink, opts Config) *batcher {
+	t.Helper()
+	b, err := newBatcher(inner, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// batchSizes returns the number of entries of every recorded batch
+func batchSizes(f *fakeSink) []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sizes := make([]int, len(f.batches))
+	for i, batch := range f.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func TestBatcherMaxEntries(t *testing.T) {
+	inner := &fakeSink{}
+	b := openBatcher(t, inner, Config{"batch_max_entries": "3", "batch_linger": "1h"})
+
+	for _, logs := range [][]logentry.LogEntry{entries("a", "b"), entries("c", "d"), entries("e", "f", "g")} {
+		if err := b.Send(context.Background(), logs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := batchSizes(inner), []int{3, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent batches of %v entries, want %v with one entry still buffered", got, want)
+	}
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inner.messages(), []string{"a", "b", "c", "d", "e", "f", "g"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
+
+func TestBatcherMaxBytes(t *testing.T) {
+	size := func(msg string) int {
+		raw, _ := json.Marshal(logentry.LogEntry{Message: msg})
+		return len(raw)
+	}
+	// Room for two of the small entries, not three
+	maxBytes := 2*size("a") + size("a")/2
+
+	inner := &fakeSink{}
+	b := openBatcher(t, inner, Config{"batch_max_bytes": strconv.Itoa(maxBytes), "batch_linger": "1h"})
+
+	big := strings.Repeat("x", maxBytes)
+	if err := b.Send(context.Background(), entries("a", "b", "c", big, "d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// An entry larger than the limit goes out on its own
+	if got, want := batchSizes(inner), []int{2, 1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent batches of %v entries, want %v", got, want)
+	}
+	if got, want := inner.messages(), []string{"a", "b", "c", big, "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries reordered: %q", got)
+	}
+}
+
+func TestBatcherLinger(t *testing.T) {
+	inner := &fakeSink{}
+	b := openBatcher(t, inner, Config{"batch_max_entries": "100", "batch_linger": "50ms"})
+
+	start := time.Now()
+	if err := b.Send(context.Background(), entries("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if got := batchSizes(inner); len(got) != 0 {
+		t.Fatalf("sent %v before the linger expired", got)
+	}
+	for len(batchSizes(inner)) == 0 {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("partial batch not sent after batch_linger")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("partial batch sent after %s, before batch_linger", waited)
+	}
+	if got, want := batchSizes(inner), []int{2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent batches of %v entries, want %v", got, want)
+	}
+}
+
+func TestBatcherCloseSendsPartialBatch(t *testing.T) {
+	inner := &fakeSink{}
+	b := openBatcher(t, inner, Config{"batch_linger": "1h"})
+	if err := b.Send(context.Background(), entries("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inner.messages(), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+	if err := b.Send(context.Background(), entries("b")); err == nil {
+		t.Error("Send after Close succeeded")
+	}
+}
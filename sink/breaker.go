@@ -0,0 +1,191 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// ErrBreakerOpen is returned for batches refused while a sink's circuit
+// breaker is open. It is retryable, so a spool keeps such batches.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// breakerOptions are the circuit breaker settings accepted by every sink spec
+var breakerOptions = []string{"breaker_failures", "breaker_cooldown", "breaker_probes"}
+
+// Breaker states as reported in Stats
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// breaker stops sending to a sink that keeps failing. After breaker_failures
+// consecutive failures it opens and refuses every batch for
+// breaker_cooldown, then lets single probe batches through. breaker_probes
+// successful probes close it again; a failed probe opens it for another
+// cooldown.
+//
+// Only failures a retry could fix count: a destination that rejects a batch
+// with a 4xx is up and answering.
+type breaker struct {
+	counters
+	inner    Sink
+	failures int
+	cooldown time.Duration
+	probes   int
+
+	mu          sync.Mutex
+	state       string
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+	succeeded   int
+	opens       atomic.Int64
+}
+
+// takeBreaker removes the circuit breaker options from cfg. It returns nil
+// settings when the spec sets none of them:
+//
+//	breaker_failures  consecutive failures that open the breaker; enables it
+//	breaker_cooldown  how long the breaker stays open before probing (default 10s)
+//	breaker_probes    successful probes needed to close it again (default 1)
+func takeBreaker(cfg Config) (Config, error) {
+	var opts Config
+	for _, key := range breakerOptions {
+		if v, ok := cfg[key]; ok {
+			if opts == nil {
+				opts = make(Config)
+			}
+			opts[key] = v
+			delete(cfg, key)
+		}
+	}
+	if opts != nil && opts.String("breaker_failures", "") == "" {
+		return nil, fmt.Errorf("breaker options need option breaker_failures")
+	}
+	return opts, nil
+}
+
+// newBreaker wraps inner in a circuit breaker configured by the options
+// returned from takeBreaker
+func newBreaker(inner Sink, opts Config) (*breaker, error) {
+	b := &breaker{inner: inner, state: BreakerClosed}
+	var err error
+	if b.failures, err = opts.Int("breaker_failures", 0); err != nil {
+		return nil, err
+	}
+	if b.cooldown, err = opts.Duration("breaker_cooldown", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if b.probes, err = opts.Int("breaker_probes", 1); err != nil {
+		return nil, err
+	}
+	if b.failures < 1 {
+		return nil, fmt.Errorf("option breaker_failures must be at least 1")
+	}
+	if b.probes < 1 {
+		return nil, fmt.Errorf("option breaker_probes must be at least 1")
+	}
+	return b, nil
+}
+
+// Send passes logs on unless the breaker is open, or half-open with a probe
+// already under way, in which case it fails at once with ErrBreakerOpen
+func (b *breaker) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	if !b.admit() {
+		b.failure(len(logs), ErrBreakerOpen)
+		return ErrBreakerOpen
+	}
+	err := b.inner.Send(ctx, logs)
+	b.record(err)
+	return err
+}
+
+// admit decides whether a batch may be sent and claims the probe slot when
+// the cooldown is over
+func (b *breaker) admit() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.succeeded = 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record updates the state with the outcome of a batch that was let through
+func (b *breaker) record(err error) {
+	failed := Retryable(err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if failed {
+			b.open()
+			return
+		}
+		if b.succeeded++; b.succeeded >= b.probes {
+			b.state = BreakerClosed
+			b.consecutive = 0
+		}
+		return
+	}
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	if b.consecutive++; b.consecutive >= b.failures && b.state == BreakerClosed {
+		b.open()
+	}
+}
+
+// open starts a cooldown. The caller holds mu.
+func (b *breaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.opens.Add(1)
+}
+
+func (b *breaker) Flush(ctx context.Context) error { return b.inner.Flush(ctx) }
+
+func (b *breaker) Close() error { return b.inner.Close() }
+
+// Stats reports the counters of the wrapped sink with the breaker state.
+// Batches refused while open count as failures and dropped entries.
+func (b *breaker) Stats() Stats {
+	stats := b.inner.Stats()
+	own := b.counters.Stats()
+	stats.Failures += own.Failures
+	stats.Dropped += own.Dropped
+
+	b.mu.Lock()
+	stats.Breaker = b.state
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		stats.Breaker = BreakerHalfOpen
+	}
+	b.mu.Unlock()
+	stats.BreakerOpens = b.opens.Load()
+	return stats
+}
@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func openBreaker(t *testing.T, inner Sink, opts Config) *breaker {
+	t.Helper()
+	b, err := newBreaker(inner, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// endCooldown makes the breaker's cooldown run out now
+func endCooldown(b *breaker) {
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.cooldown)
+	b.mu.Unlock()
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	var err error
+	inner := &fakeSink{fail: func([]logentry.LogEntry) error { return err }}
+	b := openBreaker(t, inner, Config{"breaker_failures": "3", "breaker_cooldown": "1h"})
+	send := func() error { return b.Send(context.Background(), entries("a")) }
+
+	down := errors.New("connection refused")
+	for _, step := range []struct {
+		err  error
+		want string
+	}{
+		{down, BreakerClosed},
+		{down, BreakerClosed},
+		// A success starts the count over
+		{nil, BreakerClosed},
+		{down, BreakerClosed},
+		{down, BreakerClosed},
+		// So do rejections, which come from a destination that is up
+		{&StatusError{StatusCode: http.StatusBadRequest}, BreakerClosed},
+		{down, BreakerClosed},
+		{down, BreakerClosed},
+		{&PartialError{Total: 1, Failed: 1, Err: errors.New("rejected")}, BreakerClosed},
+		{down, BreakerClosed},
+		{down, BreakerClosed},
+		{down, BreakerOpen},
+	} {
+		err = step.err
+		send()
+		if got := b.Stats().Breaker; got != step.want {
+			t.Fatalf("after sending with error %v the breaker is %s, want %s", step.err, got, step.want)
+		}
+	}
+
+	sends := inner.sends
+	err = nil
+	if got := send(); !errors.Is(got, ErrBreakerOpen) {
+		t.Errorf("Send while open = %v, want ErrBreakerOpen", got)
+	}
+	if inner.sends != sends {
+		t.Error("Send while open reached the wrapped sink")
+	}
+	if !Retryable(ErrBreakerOpen) {
+		t.Error("ErrBreakerOpen is not retryable, a spool would drop refused batches")
+	}
+	stats := b.Stats()
+	if stats.BreakerOpens != 1 || stats.Dropped != 1 {
+		t.Errorf("BreakerOpens = %d, Dropped = %d, want 1 and 1", stats.BreakerOpens, stats.Dropped)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	var err error
+	inner := &fakeSink{fail: func([]logentry.LogEntry) error { return err }}
+	b := openBreaker(t, inner, Config{"breaker_failures": "1", "breaker_cooldown": "1h", "breaker_probes": "2"})
+	send := func() error { return b.Send(context.Background(), entries("a")) }
+
+	err = errors.New("connection refused")
+	send()
+	if got := b.Stats().Breaker; got != BreakerOpen {
+		t.Fatalf("breaker is %s, want open", got)
+	}
+
+	// A failed probe opens the breaker for another cooldown
+	endCooldown(b)
+	if got := b.Stats().Breaker; got != BreakerHalfOpen {
+		t.Errorf("breaker is %s once the cooldown is over, want half-open", got)
+	}
+	send()
+	if stats := b.Stats(); stats.Breaker != BreakerOpen || stats.BreakerOpens != 2 {
+		t.Errorf("after a failed probe the breaker is %s with %d opens, want open with 2", stats.Breaker, stats.BreakerOpens)
+	}
+
+	// Only one probe is let through at a time
+	endCooldown(b)
+	if !b.admit() {
+		t.Fatal("first probe refused")
+	}
+	if b.admit() {
+		t.Error("second probe admitted while the first is under way")
+	}
+	err = nil
+	b.record(nil)
+
+	// breaker_probes successful probes close it
+	if got := b.Stats().Breaker; got != BreakerHalfOpen {
+		t.Errorf("after one of two probes the breaker is %s, want half-open", got)
+	}
+	if sendErr := send(); sendErr != nil {
+		t.Fatal(sendErr)
+	}
+	if got := b.Stats().Breaker; got != BreakerClosed {
+		t.Errorf("after two successful probes the breaker is %s, want closed", got)
+	}
+}
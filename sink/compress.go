@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// bodyEncoder compresses a request body for one Content-Encoding
+type bodyEncoder func(body []byte) ([]byte, error)
+
+// bodyEncoders are the request body compressions HTTP sinks offer, by the
+// Content-Encoding they send. snappy uses the block format, as Prometheus
+// remote write and Loki do.
+var bodyEncoders = map[string]bodyEncoder{
+	"gzip": func(body []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	},
+	"zstd": func(body []byte) ([]byte, error) {
+		return zstdEncoder.EncodeAll(body, make([]byte, 0, len(body)/2)), nil
+	},
+	"snappy": func(body []byte) ([]byte, error) {
+		return snappy.Encode(nil, body), nil
+	},
+}
+
+// zstdEncoder is shared by all sinks; EncodeAll is safe for concurrent use
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// bodyEncoderFor returns the encoder for the compression option, or nil for
+// none
+func bodyEncoderFor(compression string) (bodyEncoder, error) {
+	if compression == "none" {
+		return nil, nil
+	}
+	enc, ok := bodyEncoders[compression]
+	if !ok {
+		names := make([]string, 0, len(bodyEncoders))
+		for name := range bodyEncoders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("option compression must be %s or none, got %q", strings.Join(names, ", "), compression)
+	}
+	return enc, nil
+}
@@ -0,0 +1,203 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// deadLetterMaxResponse bounds the response body kept with a dead letter
+const deadLetterMaxResponse = 64 << 10
+
+// DeadLetter is a batch a destination rejected for good, as recorded in a
+// dead-letter file
+type DeadLetter struct {
+	Time time.Time `json:"time"`
+	// Sink is the name of the sink that rejected the batch
+	Sink string `json:"sink"`
+	// Status is the HTTP status of the rejection, or for entries rejected
+	// from a bulk request the status most of them got. It is zero for sinks
+	// that do not speak HTTP.
+	Status   int                 `json:"status,omitempty"`
+	Response string              `json:"response,omitempty"`
+	Error    string              `json:"error"`
+	Attempts int                 `json:"attempts"`
+	Logs     []logentry.LogEntry `json:"-"`
+}
+
+// deadLetterRecord is the JSON form of a DeadLetter, which keeps the
+// generator of every entry
+type deadLetterRecord struct {
+	DeadLetter
+	Logs []spoolEntry `json:"logs"`
+}
+
+// deadLetter records the batches a wrapped sink rejects for good in an
+// NDJSON file, one DeadLetter per line, so they can be inspected and resent
+// with ReadDeadLetters. Failures that may succeed when sent again pass
+// through untouched.
+type deadLetter struct {
+	inner Sink
+	name  string
+	path  string
+
+	mu       sync.Mutex
+	file     *os.File
+	recorded atomic.Int64
+}
+
+// takeDeadLetter removes the dead-letter option from cfg and returns it:
+//
+//	dead_letter  NDJSON file that rejected batches are appended to
+func takeDeadLetter(cfg Config) string {
+	path := cfg.String("dead_letter", "")
+	delete(cfg, "dead_letter")
+	return path
+}
+
+// newDeadLetter wraps inner, the sink registered as name, so that its
+// rejected batches are appended to the file at path
+func newDeadLetter(inner Sink, name, path string) (*deadLetter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening dead-letter file: %w", err)
+	}
+	return &deadLetter{inner: inner, name: name, path: path, file: f}, nil
+}
+
+// Send passes logs on and records them when the wrapped sink rejects them
+// for good. Of a batch accepted in part only the rejected entries are
+// recorded, or the whole batch when the destination did not say which
+// entries it rejected. The error of the wrapped sink is returned either way.
+func (d *deadLetter) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	err := d.inner.Send(ctx, logs)
+	if err == nil || Retryable(err) || ctx.Err() != nil {
+		return err
+	}
+	if recErr := d.record(logs, err); recErr != nil {
+		return errors.Join(err, recErr)
+	}
+	return err
+}
+
+func (d *deadLetter) record(logs []logentry.LogEntry, sendErr error) error {
+	letter := DeadLetter{
+		Time:     time.Now().UTC(),
+		Sink:     d.name,
+		Error:    sendErr.Error(),
+		Attempts: attempts(sendErr),
+	}
+	var statusErr *StatusError
+	var bulkErr *BulkError
+	if errors.As(sendErr, &statusErr) {
+		letter.Status = statusErr.StatusCode
+		letter.Response = statusErr.Body
+		if len(letter.Response) > deadLetterMaxResponse {
+			letter.Response = letter.Response[:deadLetterMaxResponse]
+		}
+	}
+	if errors.As(sendErr, &bulkErr) {
+		var most int
+		for _, reason := range bulkErr.Reasons {
+			if reason.Count > most {
+				most, letter.Status = reason.Count, reason.Status
+			}
+		}
+	}
+	if partialErr, ok := asPartial(sendErr); ok && partialErr.Items != nil {
+		logs = pickEntries(logs, partialErr.Items)
+	}
+
+	line, err := json.Marshal(deadLetterRecord{DeadLetter: letter, Logs: spoolEntries(logs)})
+	if err != nil {
+		return fmt.Errorf("encoding dead letter: %w", err)
+	}
+	line = append(line, '\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return fmt.Errorf("dead-letter file %s is closed", d.path)
+	}
+	if _, err := d.file.Write(line); err != nil {
+		return fmt.Errorf("writing dead letter to %s: %w", d.path, err)
+	}
+	d.recorded.Add(int64(len(logs)))
+	return nil
+}
+
+// pickEntries returns the entries of logs at the given positions
+func pickEntries(logs []logentry.LogEntry, items []int) []logentry.LogEntry {
+	picked := make([]logentry.LogEntry, 0, len(items))
+	for _, i := range items {
+		if i < len(logs) {
+			picked = append(picked, logs[i])
+		}
+	}
+	return picked
+}
+
+func (d *deadLetter) Flush(ctx context.Context) error { return d.inner.Flush(ctx) }
+
+// Close closes the wrapped sink, then the dead-letter file
+func (d *deadLetter) Close() error {
+	err := d.inner.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file != nil {
+		err = errors.Join(err, d.file.Close())
+		d.file = nil
+	}
+	return err
+}
+
+// Stats reports the counters of the wrapped sink with the entries recorded
+// as dead letters
+func (d *deadLetter) Stats() Stats {
+	s := d.inner.Stats()
+	s.DeadLettered = d.recorded.Load()
+	return s
+}
+
+// ReadDeadLetters calls fn for every dead letter in the file at path, in the
+// order they were recorded, and stops at the first error fn returns
+func ReadDeadLetters(path string, fn func(DeadLetter) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var rec deadLetterRecord
+			if jsonErr := json.Unmarshal(line, &rec); jsonErr != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNo, jsonErr)
+			}
+			letter := rec.DeadLetter
+			letter.Logs = logEntries(rec.Logs)
+			if fnErr := fn(letter); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// readProxy reads:
+//
+//	proxy  http://, https:// or socks5:// URL of the proxy to connect through,
+//	       with optional user:password, or none
+//
+// It returns nil when the option is unset and nil with none set to true for
+// none.
+func readProxy(cfg Config) (u *url.URL, none bool, err error) {
+	v := cfg.String("proxy", "")
+	switch v {
+	case "":
+		return nil, false, nil
+	case "none":
+		return nil, true, nil
+	}
+	u, err = url.Parse(v)
+	if err != nil {
+		return nil, false, fmt.Errorf("option proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, false, fmt.Errorf("option proxy must be an http, https or socks5 URL, got %q", v)
+	}
+	if u.Host == "" {
+		return nil, false, fmt.Errorf("option proxy: no host in %q", v)
+	}
+	return u, false, nil
+}
+
+// dialThrough connects to address directly or, when proxyURL is set,
+// through a SOCKS5 proxy or an HTTP CONNECT tunnel. Only tcp can be
+// proxied.
+func dialThrough(ctx context.Context, proxyURL *url.URL, network, address string) (net.Conn, error) {
+	direct := &net.Dialer{KeepAlive: 30 * time.Second}
+	if proxyURL == nil {
+		return direct.DialContext(ctx, network, address)
+	}
+	if network != "tcp" {
+		return nil, fmt.Errorf("cannot send %s through a proxy", network)
+	}
+	if proxyURL.Scheme == "http" || proxyURL.Scheme == "https" {
+		return dialConnect(ctx, proxyURL, address)
+	}
+	d, err := proxy.FromURL(proxyURL, direct)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	return d.(proxy.ContextDialer).DialContext(ctx, network, address)
+}
+
+// dialConnect opens a tunnel to address with an HTTP CONNECT request
+func dialConnect(ctx context.Context, proxyURL *url.URL, address string) (net.Conn, error) {
+	host := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			host = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to proxy: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("connecting to proxy: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", address, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn returns bytes the proxy sent right after its response before
+// reading from the connection again
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// streamDialer opens the connections of the syslog, Fluent and GELF sinks
+// with the sink's TLS and proxy options
+type streamDialer struct {
+	network string
+	address string
+	timeout time.Duration
+	tls     *tls.Config
+	proxy   *url.URL
+}
+
+// newStreamDialer reads the TLS options of readTLSOptions, which apply when
+// useTLS is set, and the proxy option of readProxy
+func newStreamDialer(cfg Config, network, address string, useTLS bool, timeout time.Duration) (*streamDialer, error) {
+	d := &streamDialer{network: network, address: address, timeout: timeout}
+	opts, err := readTLSOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		if d.tls, err = opts.config(host); err != nil {
+			return nil, err
+		}
+	} else if opts.custom() {
+		return nil, fmt.Errorf("tls options need network tls")
+	}
+	if d.proxy, _, err = readProxy(cfg); err != nil {
+		return nil, err
+	}
+	if d.proxy != nil && network != "tcp" {
+		return nil, fmt.Errorf("option proxy is not supported for network %s", network)
+	}
+	return d, nil
+}
+
+// dial connects and completes the TLS handshake within the timeout
+func (d *streamDialer) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	conn, err := dialThrough(ctx, d.proxy, d.network, d.address)
+	if err != nil || d.tls == nil {
+		return conn, err
+	}
+	tlsConn := tls.Client(conn, d.tls)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
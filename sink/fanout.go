@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// FanOut hands every batch to several sinks. Each target sends through its
+// own Pool, so a slow or failing destination only backs up its own queue and
+// never delays the generators or the other targets.
+type FanOut struct {
+	targets []*fanOutTarget
+	// OnError is called from the worker goroutines with the target name when
+	// a batch fails. It may be nil.
+	OnError func(target string, err error)
+	// OnResult is called with the target name for every send and every
+	// batch a full queue dropped, see Pool.OnResult. It may be nil.
+	OnResult func(target string, took time.Duration, err error)
+
+	closeOnce sync.Once
+}
+
+// fanOutTarget is one destination of a FanOut
+type fanOutTarget struct {
+	name string
+	pool *Pool
+}
+
+// TargetStats reports the delivery counters and queue state of one target
+type TargetStats struct {
+	Name  string `json:"name"`
+	Stats Stats  `json:"stats"`
+}
+
+// Specs collects repeated --sink flags
+type Specs []string
+
+func (s *Specs) String() string { return strings.Join(*s, " ") }
+
+func (s *Specs) Set(spec string) error {
+	*s = append(*s, spec)
+	return nil
+}
+
+// OpenFanOut opens every spec as a target of one FanOut. Besides the options
+// of its sink, each spec accepts name, a label used in stats and errors
+// (default the sink name), and the pool options of takePool.
+func OpenFanOut(specs []string) (*FanOut, error) {
+	f := &FanOut{}
+	seen := make(map[string]int)
+	for _, spec := range specs {
+		name, cfg, err := ParseSpec(spec)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		label := cfg.String("name", name)
+		if seen[label]++; seen[label] > 1 {
+			label = fmt.Sprintf("%s#%d", label, seen[label])
+		}
+		delete(cfg, "name")
+		queue, workers, block, err := takePool(cfg)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("sink %s: %w", label, err)
+		}
+
+		s, err := New(name, cfg)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.Add(label, s, queue, workers, block)
+	}
+	return f, nil
+}
+
+// takePool removes the pool options from cfg:
+//
+//	queue    batches buffered for the target (default 100)
+//	workers  concurrent senders for the target (default 1)
+//	on_full  drop or block when the queue is full (default drop)
+func takePool(cfg Config) (queue, workers int, block bool, err error) {
+	defer func() {
+		for _, key := range poolOptions {
+			delete(cfg, key)
+		}
+	}()
+	if queue, err = cfg.Int("queue", DefaultQueueSize); err != nil {
+		return
+	}
+	if workers, err = cfg.Int("workers", 1); err != nil {
+		return
+	}
+	onFull := cfg.String("on_full", "drop")
+	if onFull != "drop" && onFull != "block" {
+		err = fmt.Errorf("option on_full must be drop or block, got %q", onFull)
+		return
+	}
+	return queue, workers, onFull == "block", nil
+}
+
+// Add sends to s through a new Pool, see NewPool
+func (f *FanOut) Add(name string, s Sink, queueSize, workers int, block bool) {
+	t := &fanOutTarget{name: name, pool: NewPool(s, queueSize, workers, block)}
+	t.pool.OnError = func(err error) {
+		if f.OnError != nil {
+			f.OnError(name, err)
+		}
+	}
+	t.pool.OnResult = func(took time.Duration, err error) {
+		if f.OnResult != nil {
+			f.OnResult(name, took, err)
+		}
+	}
+	f.targets = append(f.targets, t)
+}
+
+// Send queues logs for every target. A target whose queue is full drops the
+// batch and counts it, unless it was configured to block.
+func (f *FanOut) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	for _, t := range f.targets {
+		if err := t.pool.Send(ctx, logs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush waits until every queued batch has been sent and flushes each target
+func (f *FanOut) Flush(ctx context.Context) error {
+	var errs []string
+	for _, t := range f.targets {
+		if err := t.pool.Flush(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", t.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("flushing sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close drains the queues and closes every target. Send must not be called
+// afterwards.
+func (f *FanOut) Close() error {
+	var errs []string
+	f.closeOnce.Do(func() {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, t := range f.targets {
+			wg.Add(1)
+			go func(t *fanOutTarget) {
+				defer wg.Done()
+				if err := t.pool.Close(); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("%s: %v", t.name, err))
+					mu.Unlock()
+				}
+			}(t)
+		}
+		wg.Wait()
+	})
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("closing sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Stats sums the counters of all targets and reports the breaker state of
+// the worst off one
+func (f *FanOut) Stats() Stats {
+	var total Stats
+	for _, ts := range f.TargetStats() {
+		total.Batches += ts.Stats.Batches
+		total.Entries += ts.Stats.Entries
+		total.Bytes += ts.Stats.Bytes
+		total.Failures += ts.Stats.Failures
+		total.Dropped += ts.Stats.Dropped
+		total.Retries += ts.Stats.Retries
+		total.WireBytes += ts.Stats.WireBytes
+		total.Spooled += ts.Stats.Spooled
+		total.SpoolBytes += ts.Stats.SpoolBytes
+		total.Queued += ts.Stats.Queued
+		total.QueueDropped += ts.Stats.QueueDropped
+		total.DeadLettered += ts.Stats.DeadLettered
+		total.BreakerOpens += ts.Stats.BreakerOpens
+		total.Breaker = worseBreaker(total.Breaker, ts.Stats.Breaker)
+		if ts.Stats.LastError != "" {
+			total.LastError = ts.Name + ": " + ts.Stats.LastError
+		}
+	}
+	return total
+}
+
+// worseBreaker returns the breaker state of a and b that stops more batches
+func worseBreaker(a, b string) string {
+	rank := map[string]int{"": 0, BreakerClosed: 1, BreakerHalfOpen: 2, BreakerOpen: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// TargetStats reports each target separately
+func (f *FanOut) TargetStats() []TargetStats {
+	out := make([]TargetStats, len(f.targets))
+	for i, t := range f.targets {
+		out[i] = TargetStats{Name: t.name, Stats: t.pool.Stats()}
+	}
+	return out
+}
@@ -0,0 +1,380 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("file", newFileSink)
+}
+
+// rotatedSuffix is the time layout appended to rotated file names. It sorts
+// lexically in rotation order.
+const rotatedSuffix = "20060102T150405.000000000"
+
+// fileSink appends entries to a local file and rotates it by size or age, so
+// that file tailing agents can be tested against rotation
+type fileSink struct {
+	counters
+	path        string
+	text        bool
+	maxSize     int64
+	rotateEvery time.Duration
+	copyTrunc   bool
+	compress    bool
+	maxFiles    int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	gzipping sync.WaitGroup
+	pruneMux sync.Mutex
+}
+
+// newFileSink reads:
+//
+//	path          file to write (required)
+//	format        ndjson or text (default ndjson)
+//	max_size      rotate once the file would grow beyond this size, e.g. 100MB (default no limit)
+//	rotate_every  rotate files older than this, e.g. 1h (default never)
+//	mode          rename (move the file away and reopen) or copytruncate (copy, then truncate in place) (default rename)
+//	compress      gzip rotated files (default false)
+//	max_files     rotated files to keep, oldest are deleted first (default 0, keep all)
+func newFileSink(cfg Config) (Sink, error) {
+	path := cfg.String("path", "")
+	if path == "" {
+		return nil, fmt.Errorf("option path is required")
+	}
+	format := cfg.String("format", "ndjson")
+	if format != "ndjson" && format != "text" {
+		return nil, fmt.Errorf("option format must be ndjson or text, got %q", format)
+	}
+	mode := cfg.String("mode", "rename")
+	if mode != "rename" && mode != "copytruncate" {
+		return nil, fmt.Errorf("option mode must be rename or copytruncate, got %q", mode)
+	}
+	maxSize, err := cfg.Size("max_size", 0)
+	if err != nil {
+		return nil, err
+	}
+	rotateEvery, err := cfg.Duration("rotate_every", 0)
+	if err != nil {
+		return nil, err
+	}
+	compress, err := cfg.Bool("compress", false)
+	if err != nil {
+		return nil, err
+	}
+	maxFiles, err := cfg.Int("max_files", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{
+		path:        path,
+		text:        format == "text",
+		maxSize:     maxSize,
+		rotateEvery: rotateEvery,
+		copyTrunc:   mode == "copytruncate",
+		compress:    compress,
+		maxFiles:    maxFiles,
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating log directory: %w", err)
+		}
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening log file: %w", err)
+	}
+	s.file = f
+	s.size = info.Size()
+	s.openedAt = time.Now()
+	return nil
+}
+
+func (s *fileSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range logs {
+		if err := s.format(&buf, entry); err != nil {
+			s.failure(len(logs), err)
+			return fmt.Errorf("encoding log entry: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		err := fmt.Errorf("file sink is closed")
+		s.failure(len(logs), err)
+		return err
+	}
+	if s.needsRotation(int64(buf.Len())) {
+		if err := s.rotate(); err != nil {
+			s.failure(len(logs), err)
+			return err
+		}
+	}
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		err = fmt.Errorf("writing log file: %w", err)
+		s.failure(len(logs), err)
+		return err
+	}
+	s.success(len(logs), n)
+	return nil
+}
+
+// format writes one line for entry. The text format matches the dashboard:
+// timestamp [LEVEL] service: message, followed by the remaining fields as
+// key=value pairs.
+func (s *fileSink) format(buf *bytes.Buffer, entry logentry.LogEntry) error {
+	if !s.text {
+		return json.NewEncoder(buf).Encode(entry)
+	}
+	fmt.Fprintf(buf, "%s [%s] %s: %s", entry.Timestamp, entry.Level, entry.Service, entry.Message)
+
+	fields, err := entryMap(entry)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"timestamp", "level", "service", "message"} {
+		delete(fields, name)
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fields[k]
+		if str, ok := v.(string); ok && !strings.ContainsAny(str, " \"=") {
+			fmt.Fprintf(buf, " %s=%s", k, str)
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, " %s=%s", k, raw)
+	}
+	buf.WriteByte('\n')
+	return nil
+}
+
+// needsRotation reports whether the file must be rotated before writing n
+// more bytes. An empty file is never rotated, so a single oversized batch
+// still gets written.
+func (s *fileSink) needsRotation(n int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.maxSize > 0 && s.size+n > s.maxSize {
+		return true
+	}
+	return s.rotateEvery > 0 && time.Since(s.openedAt) >= s.rotateEvery
+}
+
+// rotate moves the current content to a timestamped file next to path. The
+// caller holds mu.
+func (s *fileSink) rotate() error {
+	rotated := s.rotatedName()
+
+	if s.copyTrunc {
+		// Tailers keep reading the same inode, which shrinks back to zero
+		if err := copyFile(s.path, rotated); err != nil {
+			return fmt.Errorf("copying log file: %w", err)
+		}
+		if err := s.file.Truncate(0); err != nil {
+			return fmt.Errorf("truncating log file: %w", err)
+		}
+		s.size = 0
+		s.openedAt = time.Now()
+	} else {
+		// Tailers see the old inode move away and a new file appear
+		err := s.file.Close()
+		s.file = nil
+		if err != nil {
+			err = fmt.Errorf("closing log file: %w", err)
+		} else if err = os.Rename(s.path, rotated); err != nil {
+			err = fmt.Errorf("renaming log file: %w", err)
+		}
+		// Reopen path either way: after a failed rename later batches go on
+		// appending to the unrotated file instead of failing
+		if openErr := s.open(); openErr != nil {
+			return openErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.compress {
+		s.gzipping.Add(1)
+		go func() {
+			defer s.gzipping.Done()
+			if err := gzipFile(rotated); err != nil {
+				s.lastErr.Store(err.Error())
+			}
+			s.prune()
+		}()
+		return nil
+	}
+	s.prune()
+	return nil
+}
+
+// rotatedName returns an unused timestamped name for the next rotated file
+func (s *fileSink) rotatedName() string {
+	ts := time.Now()
+	for {
+		name := s.path + "." + ts.Format(rotatedSuffix)
+		_, err := os.Stat(name)
+		_, gzErr := os.Stat(name + ".gz")
+		if os.IsNotExist(err) && os.IsNotExist(gzErr) {
+			return name
+		}
+		ts = ts.Add(time.Nanosecond)
+	}
+}
+
+// prune deletes the oldest rotated files beyond max_files. With compression
+// only finished archives count, so files still being compressed are left
+// alone.
+func (s *fileSink) prune() {
+	if s.maxFiles <= 0 {
+		return
+	}
+	s.pruneMux.Lock()
+	defer s.pruneMux.Unlock()
+
+	matches, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, m := range matches {
+		if isRotatedName(s.path, m) && (!s.compress || strings.HasSuffix(m, ".gz")) {
+			rotated = append(rotated, m)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > s.maxFiles {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+// isRotatedName reports whether name is a file rotated from path, so that
+// other files sharing the prefix, such as path.bak, are never pruned
+func isRotatedName(path, name string) bool {
+	suffix, ok := strings.CutPrefix(name, path+".")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(rotatedSuffix, strings.TrimSuffix(suffix, ".gz"))
+	return err == nil
+}
+
+func (s *fileSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	var err error
+	if s.file != nil {
+		err = s.file.Close()
+		s.file = nil
+	}
+	s.mu.Unlock()
+
+	s.gzipping.Wait()
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// gzipFile replaces path with path.gz. It writes to a temporary name first so
+// that a tailer never sees a partial archive.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("compressing rotated file: %w", err)
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("compressing rotated file: %w", err)
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compressing rotated file: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compressing rotated file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compressing rotated file: %w", err)
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return fmt.Errorf("compressing rotated file: %w", err)
+	}
+	return os.Remove(path)
+}
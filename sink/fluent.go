@@ -0,0 +1,367 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("fluent", newFluentSink)
+}
+
+// Forward protocol modes, see
+// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+const (
+	fluentMessage    = "message"
+	fluentForward    = "forward"
+	fluentPacked     = "packed"
+	fluentCompressed = "compressed"
+)
+
+// fluentSink speaks the Fluent Forward protocol to Fluentd or Fluent Bit.
+// Entries are grouped by tag, and each group is sent as one Forward,
+// PackedForward or CompressedPackedForward message, or as one Message per
+// entry.
+type fluentSink struct {
+	counters
+	dialer     *streamDialer
+	mode       string
+	tag        entryTemplate
+	eventTime  bool
+	ack        bool
+	timeout    time.Duration
+	ackTimeout time.Duration
+
+	connMux sync.Mutex
+	conn    net.Conn
+	pending []byte // unread bytes of ack responses
+}
+
+// newFluentSink reads:
+//
+//	address      host:port of the forward input (required)
+//	network      tcp or tls (default tcp)
+//	mode         message, forward, packed or compressed (default forward)
+//	tag          tag template (default loggen.{generator}.{service}), see entryTemplate
+//	event_time   send EventTime with nanoseconds instead of integer seconds (default true)
+//	ack          request and wait for chunk acknowledgements (default false)
+//	ack_timeout  how long to wait for an acknowledgement (default 30s)
+//	timeout      dial and write timeout (default 10s)
+//
+// It also accepts the TLS options of readTLSOptions for network tls and the
+// proxy option of readProxy.
+func newFluentSink(cfg Config) (Sink, error) {
+	address := cfg.String("address", "")
+	if address == "" {
+		return nil, fmt.Errorf("option address is required")
+	}
+	network := cfg.String("network", "tcp")
+	if network != "tcp" && network != "tls" {
+		return nil, fmt.Errorf("option network must be tcp or tls, got %q", network)
+	}
+	mode := cfg.String("mode", fluentForward)
+	switch mode {
+	case fluentMessage, fluentForward, fluentPacked, fluentCompressed:
+	default:
+		return nil, fmt.Errorf("option mode must be message, forward, packed or compressed, got %q", mode)
+	}
+	tag, err := parseEntryTemplate(cfg.String("tag", "loggen.{generator}.{service}"))
+	if err != nil {
+		return nil, err
+	}
+	eventTime, err := cfg.Bool("event_time", true)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := cfg.Bool("ack", false)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := cfg.Duration("timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ackTimeout, err := cfg.Duration("ack_timeout", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := newStreamDialer(cfg, "tcp", address, network == "tls", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fluentSink{
+		dialer:     dialer,
+		mode:       mode,
+		tag:        tag,
+		eventTime:  eventTime,
+		ack:        ack,
+		timeout:    timeout,
+		ackTimeout: ackTimeout,
+	}
+	return s, nil
+}
+
+// fluentEvent is an encoded [time, record] pair
+type fluentEvent struct {
+	time   []byte
+	record []byte
+}
+
+func (s *fluentSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	groups := make(map[string][]fluentEvent)
+	var tags []string
+	for _, entry := range logs {
+		ev, err := s.event(entry)
+		if err != nil {
+			s.failure(len(logs), err)
+			return err
+		}
+		tag := s.tag.render(entry)
+		if _, ok := groups[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		groups[tag] = append(groups[tag], ev)
+	}
+
+	var written int
+	for _, tag := range tags {
+		events := groups[tag]
+		var msgs [][]byte
+		var chunks []string
+		if s.mode == fluentMessage {
+			for _, ev := range events {
+				msg, chunk, err := s.message(tag, []fluentEvent{ev})
+				if err != nil {
+					s.failure(len(logs), err)
+					return err
+				}
+				msgs = append(msgs, msg)
+				chunks = append(chunks, chunk)
+			}
+		} else {
+			msg, chunk, err := s.message(tag, events)
+			if err != nil {
+				s.failure(len(logs), err)
+				return err
+			}
+			msgs = append(msgs, msg)
+			chunks = append(chunks, chunk)
+		}
+
+		for i, msg := range msgs {
+			if err := s.deliver(ctx, msg, chunks[i]); err != nil {
+				s.failure(len(logs), err)
+				return err
+			}
+			written += len(msg)
+		}
+	}
+	s.success(len(logs), written)
+	return nil
+}
+
+// event encodes the time and record of one entry
+func (s *fluentSink) event(entry logentry.LogEntry) (fluentEvent, error) {
+	ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+	var tw msgpackWriter
+	if s.eventTime {
+		var data [8]byte
+		binary.BigEndian.PutUint32(data[:4], uint32(ts.Unix()))
+		binary.BigEndian.PutUint32(data[4:], uint32(ts.Nanosecond()))
+		tw.ext8(0, data)
+	} else {
+		tw.int(ts.Unix())
+	}
+
+	fields, err := entryMap(entry)
+	if err != nil {
+		return fluentEvent{}, fmt.Errorf("encoding log entry: %w", err)
+	}
+	var rw msgpackWriter
+	if err := rw.value(fields); err != nil {
+		return fluentEvent{}, fmt.Errorf("encoding log entry: %w", err)
+	}
+	return fluentEvent{time: tw.buf, record: rw.buf}, nil
+}
+
+// message encodes one protocol message for tag and returns it with the chunk
+// id it asks the server to acknowledge, if any
+func (s *fluentSink) message(tag string, events []fluentEvent) ([]byte, string, error) {
+	var w msgpackWriter
+	switch s.mode {
+	case fluentMessage:
+		// [tag, time, record, option]
+		w.arrayHeader(4)
+		w.str(tag)
+		w.buf = append(w.buf, events[0].time...)
+		w.buf = append(w.buf, events[0].record...)
+	case fluentForward:
+		// [tag, [[time, record], ...], option]
+		w.arrayHeader(3)
+		w.str(tag)
+		w.arrayHeader(len(events))
+		for _, ev := range events {
+			w.arrayHeader(2)
+			w.buf = append(w.buf, ev.time...)
+			w.buf = append(w.buf, ev.record...)
+		}
+	default:
+		// [tag, MessagePackEventStream, option], where the stream is the
+		// concatenated [time, record] arrays, optionally gzipped
+		var stream msgpackWriter
+		for _, ev := range events {
+			stream.arrayHeader(2)
+			stream.buf = append(stream.buf, ev.time...)
+			stream.buf = append(stream.buf, ev.record...)
+		}
+		entries := stream.buf
+		if s.mode == fluentCompressed {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			if _, err := zw.Write(entries); err != nil {
+				return nil, "", fmt.Errorf("compressing entries: %w", err)
+			}
+			if err := zw.Close(); err != nil {
+				return nil, "", fmt.Errorf("compressing entries: %w", err)
+			}
+			entries = buf.Bytes()
+		}
+		w.arrayHeader(3)
+		w.str(tag)
+		w.bin(entries)
+	}
+
+	// option map: size, compressed and chunk
+	var chunk string
+	fields := 1
+	if s.mode == fluentCompressed {
+		fields++
+	}
+	if s.ack {
+		fields++
+		var id [16]byte
+		if _, err := rand.Read(id[:]); err != nil {
+			return nil, "", fmt.Errorf("generating chunk id: %w", err)
+		}
+		chunk = base64.StdEncoding.EncodeToString(id[:])
+	}
+	w.mapHeader(fields)
+	w.str("size")
+	w.int(int64(len(events)))
+	if s.mode == fluentCompressed {
+		w.str("compressed")
+		w.str("gzip")
+	}
+	if chunk != "" {
+		w.str("chunk")
+		w.str(chunk)
+	}
+	return w.buf, chunk, nil
+}
+
+// deliver writes msg, reconnecting once if the connection was lost, and
+// waits for the acknowledgement of chunk when acks are enabled
+func (s *fluentSink) deliver(ctx context.Context, msg []byte, chunk string) error {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+
+	// Only a message of which nothing was written is sent again, resending
+	// after a partial write would leave the input a broken message and
+	// duplicates
+	n, err := s.write(ctx, msg)
+	if err != nil && n == 0 {
+		s.closeConn()
+		_, err = s.write(ctx, msg)
+	}
+	if err != nil {
+		s.closeConn()
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+	if err := s.readAck(chunk); err != nil {
+		// The response stream is no longer in sync with our requests
+		s.closeConn()
+		return err
+	}
+	return nil
+}
+
+// write sends b, dialing first if needed, and returns how much of b was
+// written. The caller holds connMux.
+func (s *fluentSink) write(ctx context.Context, b []byte) (int, error) {
+	if s.conn == nil {
+		conn, err := s.dialer.dial(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("connecting to forward input: %w", err)
+		}
+		s.conn = conn
+		s.pending = nil
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	n, err := s.conn.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("writing forward message: %w", err)
+	}
+	return n, nil
+}
+
+// readAck reads responses until the one for chunk arrives. The caller holds
+// connMux.
+func (s *fluentSink) readAck(chunk string) error {
+	s.conn.SetReadDeadline(time.Now().Add(s.ackTimeout))
+	buf := make([]byte, 512)
+	for {
+		if len(s.pending) > 0 {
+			resp, n, err := msgpackReadMap(s.pending)
+			if err != nil {
+				return fmt.Errorf("decoding ack response: %w", err)
+			}
+			if n > 0 {
+				s.pending = s.pending[n:]
+				if resp["ack"] == chunk {
+					return nil
+				}
+				continue
+			}
+		}
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("waiting for ack of chunk %s: %w", chunk, err)
+		}
+		s.pending = append(s.pending, buf[:n]...)
+	}
+}
+
+func (s *fluentSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		s.pending = nil
+	}
+}
+
+func (s *fluentSink) Flush(ctx context.Context) error { return nil }
+
+func (s *fluentSink) Close() error {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+	s.closeConn()
+	return nil
+}
@@ -0,0 +1,383 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("gelf", newGELFSink)
+}
+
+const (
+	// gelfMaxChunks is the most chunks Graylog reassembles for one message
+	gelfMaxChunks = 128
+	// gelfChunkHeader is the magic bytes, message id, sequence number and
+	// sequence count that precede every chunk
+	gelfChunkHeader = 12
+)
+
+// gelfSink sends GELF 1.1 messages to Graylog over chunked UDP, null byte
+// delimited TCP or HTTP
+type gelfSink struct {
+	counters
+	network     string
+	url         string
+	host        string
+	compression string
+	chunkSize   int
+	timeout     time.Duration
+	dialer      *streamDialer
+	poster      *poster
+
+	connMux sync.Mutex
+	conn    net.Conn
+}
+
+// newGELFSink reads:
+//
+//	network      udp, tcp, tls or http (default udp)
+//	address      Graylog input host:port for udp, tcp and tls
+//	url          GELF HTTP input URL, e.g. http://graylog:12201/gelf, for http
+//	host         GELF host field (default the local hostname)
+//	compression  gzip, zlib or none for udp (default gzip); for http see the poster options
+//	chunk_size   largest UDP datagram including the chunk header (default 1420)
+//	timeout      dial, write and request timeout (default 10s)
+//
+// The http network also accepts the poster options. The other networks accept
+// the TLS options of readTLSOptions for tls and the proxy option of readProxy
+// for tcp and tls.
+func newGELFSink(cfg Config) (Sink, error) {
+	network := cfg.String("network", "udp")
+	hostname, _ := os.Hostname()
+	s := &gelfSink{network: network, host: cfg.String("host", hostname)}
+
+	var err error
+	if s.timeout, err = cfg.Duration("timeout", defaultTimeout); err != nil {
+		return nil, err
+	}
+	if s.chunkSize, err = cfg.Int("chunk_size", 1420); err != nil {
+		return nil, err
+	}
+	if s.chunkSize <= gelfChunkHeader {
+		return nil, fmt.Errorf("option chunk_size must be larger than %d", gelfChunkHeader)
+	}
+
+	switch network {
+	case "udp", "tcp", "tls":
+		address := cfg.String("address", "")
+		if address == "" {
+			return nil, fmt.Errorf("option address is required for network %s", network)
+		}
+		dialNetwork := network
+		if network == "tls" {
+			dialNetwork = "tcp"
+		}
+		if s.dialer, err = newStreamDialer(cfg, dialNetwork, address, network == "tls", s.timeout); err != nil {
+			return nil, err
+		}
+	case "http":
+		if s.url = cfg.String("url", ""); s.url == "" {
+			return nil, fmt.Errorf("option url is required for network http")
+		}
+		if s.poster, err = newPoster(cfg); err != nil {
+			return nil, err
+		}
+		s.poster.stats = &s.counters
+	default:
+		return nil, fmt.Errorf("option network must be udp, tcp, tls or http, got %q", network)
+	}
+
+	if network == "udp" {
+		s.compression = cfg.String("compression", "gzip")
+		switch s.compression {
+		case "gzip", "zlib", "none":
+		default:
+			return nil, fmt.Errorf("option compression must be gzip, zlib or none, got %q", s.compression)
+		}
+	}
+	return s, nil
+}
+
+// gelfLevel maps generator levels onto syslog severities
+var gelfLevel = map[string]int{
+	"FATAL": 2,
+	"ERROR": 3,
+	"WARN":  4,
+	"INFO":  6,
+	"DEBUG": 7,
+}
+
+// message maps an entry onto a GELF 1.1 payload. Entry fields other than
+// message, level and timestamp become additional fields, and metadata keys
+// are flattened into additional fields of their own.
+func (s *gelfSink) message(entry logentry.LogEntry) ([]byte, error) {
+	ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+	level, ok := gelfLevel[strings.ToUpper(entry.Level)]
+	if !ok {
+		level = 5
+	}
+
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          s.host,
+		"short_message": entry.Message,
+		"timestamp":     float64(ts.UnixNano()) / 1e9,
+		"level":         level,
+	}
+	add := func(name string, v interface{}, set bool) {
+		if set {
+			msg["_"+name] = v
+		}
+	}
+	add("service", entry.Service, entry.Service != "")
+	add("environment", entry.Environment, entry.Environment != "")
+	add("status_code", entry.StatusCode, entry.StatusCode != 0)
+	add("method", entry.Method, entry.Method != "")
+	add("path", entry.Path, entry.Path != "")
+	add("duration", entry.Duration, entry.Duration != 0)
+	add("user_id", entry.UserID, entry.UserID != "")
+	add("action", entry.Action, entry.Action != "")
+	add("generator", entry.Generator, entry.Generator != "")
+
+	if entry.Metadata != nil {
+		fields, ok := entry.Metadata.(map[string]interface{})
+		if !ok {
+			raw, _ := json.Marshal(entry.Metadata)
+			if json.Unmarshal(raw, &fields) != nil {
+				fields = map[string]interface{}{"metadata": string(raw)}
+			}
+		}
+		for name, v := range fields {
+			// _id is reserved and values must be strings or numbers
+			if name == "id" {
+				name = "metadata_id"
+			}
+			switch v.(type) {
+			case string, int, int64, float64:
+			default:
+				raw, _ := json.Marshal(v)
+				v = string(raw)
+			}
+			msg["_"+name] = v
+		}
+	}
+	return json.Marshal(msg)
+}
+
+func (s *gelfSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	msgs := make([][]byte, len(logs))
+	for i, entry := range logs {
+		msg, err := s.message(entry)
+		if err != nil {
+			s.failure(len(logs), err)
+			return fmt.Errorf("encoding GELF message: %w", err)
+		}
+		msgs[i] = msg
+	}
+
+	if s.network == "http" {
+		return s.sendHTTP(ctx, logs, msgs)
+	}
+
+	var written int
+	var err error
+	switch s.network {
+	case "udp":
+		written, err = s.sendUDP(ctx, msgs)
+	default:
+		written, err = s.sendTCP(ctx, msgs)
+	}
+	if err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+	s.success(len(logs), written)
+	return nil
+}
+
+// sendHTTP posts one message per request, as the GELF HTTP input accepts a
+// single message per body. It stops at the first message that fails, and
+// when earlier ones were delivered reports the rest as a *PartialError, so
+// that only the rest is sent again.
+func (s *gelfSink) sendHTTP(ctx context.Context, logs []logentry.LogEntry, msgs [][]byte) error {
+	var written int
+	for i, msg := range msgs {
+		if _, err := s.poster.post(ctx, s.url, "application/json", msg); err != nil {
+			if i == 0 {
+				s.failure(len(logs), err)
+				return err
+			}
+			partialErr := &PartialError{
+				Total:  len(logs),
+				Failed: len(logs) - i,
+				Retry:  Retryable(err),
+				Err:    fmt.Errorf("%d of %d GELF messages not sent: %w", len(logs)-i, len(logs), err),
+			}
+			for j := i; j < len(logs); j++ {
+				partialErr.Items = append(partialErr.Items, j)
+			}
+			s.partial(i, len(logs)-i, written, partialErr)
+			return partialErr
+		}
+		written += len(msg)
+	}
+	s.success(len(logs), written)
+	return nil
+}
+
+// sendTCP writes null byte terminated messages, reconnecting once if the
+// connection was dropped since the last batch
+func (s *gelfSink) sendTCP(ctx context.Context, msgs [][]byte) (int, error) {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		buf.Write(msg)
+		buf.WriteByte(0)
+	}
+
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+	// Only a batch of which nothing was written is sent again, resending
+	// after a partial write would leave the input a broken message and
+	// duplicates
+	n, err := s.write(ctx, buf.Bytes())
+	if err != nil && n == 0 {
+		s.closeConn()
+		_, err = s.write(ctx, buf.Bytes())
+	}
+	if err != nil {
+		s.closeConn()
+		return 0, err
+	}
+	return buf.Len(), nil
+}
+
+// sendUDP compresses each message and splits it into chunks when it does not
+// fit into one datagram
+func (s *gelfSink) sendUDP(ctx context.Context, msgs [][]byte) (int, error) {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+
+	var written int
+	for _, msg := range msgs {
+		payload, err := gelfCompress(msg, s.compression)
+		if err != nil {
+			return written, err
+		}
+		datagrams, err := gelfChunks(payload, s.chunkSize)
+		if err != nil {
+			return written, err
+		}
+		for _, d := range datagrams {
+			if _, err := s.write(ctx, d); err != nil {
+				s.closeConn()
+				return written, err
+			}
+			written += len(d)
+		}
+	}
+	return written, nil
+}
+
+// write sends b, dialing first if needed, and returns how much of b was
+// written. The caller holds connMux.
+func (s *gelfSink) write(ctx context.Context, b []byte) (int, error) {
+	if s.conn == nil {
+		conn, err := s.dialer.dial(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("connecting to GELF input: %w", err)
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	n, err := s.conn.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("writing GELF message: %w", err)
+	}
+	return n, nil
+}
+
+func (s *gelfSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *gelfSink) Flush(ctx context.Context) error { return nil }
+
+func (s *gelfSink) Close() error {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+	s.closeConn()
+	return nil
+}
+
+func gelfCompress(msg []byte, compression string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	default:
+		return msg, nil
+	}
+	if _, err := w.Write(msg); err != nil {
+		return nil, fmt.Errorf("compressing GELF message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("compressing GELF message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfChunks splits payload into datagrams of at most size bytes. A payload
+// that fits is sent as is; otherwise every chunk starts with 0x1e 0x0f, an 8
+// byte message id, the sequence number and the sequence count.
+func gelfChunks(payload []byte, size int) ([][]byte, error) {
+	if len(payload) <= size {
+		return [][]byte{payload}, nil
+	}
+	body := size - gelfChunkHeader
+	count := (len(payload) + body - 1) / body
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("GELF message of %d bytes needs %d chunks, more than the %d Graylog accepts", len(payload), count, gelfMaxChunks)
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("generating GELF message id: %w", err)
+	}
+	chunks := make([][]byte, 0, count)
+	for seq := 0; seq < count; seq++ {
+		end := (seq + 1) * body
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk := make([]byte, 0, gelfChunkHeader+end-seq*body)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id[:]...)
+		chunk = append(chunk, byte(seq), byte(count))
+		chunk = append(chunk, payload[seq*body:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,251 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"log-generator/logentry"
+)
+
+const (
+	// DefaultEasyLogsURL is the ingestion endpoint used by the easylogs sink
+	DefaultEasyLogsURL = "https://ingestion.easylogs.co/logs"
+
+	defaultTimeout = 10 * time.Second
+)
+
+func init() {
+	Register("http", newHTTPSink)
+	Register("easylogs", func(cfg Config) (Sink, error) {
+		if cfg.String("url", "") == "" {
+			cfg["url"] = DefaultEasyLogsURL
+		}
+		return newHTTPSink(cfg)
+	})
+}
+
+// StatusError is returned when a destination answers with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the wait requested by a Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.StatusCode, e.Body)
+}
+
+// poster sends request bodies to HTTP destinations with the headers shared by
+// every request of one sink, retrying failures the destination may recover
+// from
+type poster struct {
+	client      *http.Client
+	header      http.Header
+	retry       RetryPolicy
+	compression string
+	encode      bodyEncoder
+	// inFlight limits concurrent requests when max_in_flight is set
+	inFlight chan struct{}
+	// sign, when set, signs every attempt after all headers are in place
+	sign func(req *http.Request, body []byte) error
+	// stats counts retries and bytes on the wire for the owning sink. It
+	// may be nil.
+	stats *counters
+}
+
+// newPoster reads the options shared by HTTP based sinks, plus the retry
+// options of newRetryPolicy and the transport options of newTransportKey:
+//
+//	timeout        request timeout, per attempt (default 10s)
+//	auth           raw Authorization header value
+//	token          bearer token, shorthand for auth=Bearer <token>
+//	username       Basic auth user, with password
+//	password       Basic auth password
+//	header.X       extra request header X, e.g. header.X-Api-Key=secret
+//	compression    request body Content-Encoding: gzip, zstd, snappy or none (default none)
+//	max_in_flight  concurrent requests of the sink, 0 for no limit (default 0)
+func newPoster(cfg Config) (*poster, error) {
+	timeout, err := cfg.Duration("timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	retry, err := newRetryPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	compression := cfg.String("compression", "none")
+	encode, err := bodyEncoderFor(compression)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newTransportKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	maxInFlight, err := cfg.Int("max_in_flight", 0)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := sharedTransport(transport)
+	if err != nil {
+		return nil, err
+	}
+	p := &poster{
+		client: &http.Client{Timeout: timeout, Transport: shared},
+		header: make(http.Header),
+		retry:  retry,
+		encode: encode,
+	}
+	if maxInFlight > 0 {
+		p.inFlight = make(chan struct{}, maxInFlight)
+	}
+	if encode != nil {
+		p.compression = compression
+	}
+	if auth := cfg.String("auth", ""); auth != "" {
+		p.header.Set("Authorization", auth)
+	} else if token := cfg.String("token", ""); token != "" {
+		p.header.Set("Authorization", "Bearer "+token)
+	} else if user := cfg.String("username", ""); user != "" {
+		creds := user + ":" + cfg.String("password", "")
+		p.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds)))
+	}
+	for key, val := range cfg {
+		if name, ok := strings.CutPrefix(key, "header."); ok && name != "" {
+			p.header.Set(name, val)
+		}
+	}
+	return p, nil
+}
+
+// post sends body to url, compressed if configured, and returns the response
+// body. Responses with a status of 400 or above are reported as
+// *StatusError. Transport errors, 429 and 5xx responses are retried
+// according to the retry policy.
+func (p *poster) post(ctx context.Context, url, contentType string, body []byte) ([]byte, error) {
+	if p.encode != nil {
+		var err error
+		if body, err = p.encode(body); err != nil {
+			return nil, fmt.Errorf("compressing request body: %w", err)
+		}
+	}
+
+	var respBody []byte
+	var onRetry func()
+	if p.stats != nil {
+		onRetry = p.stats.retried
+	}
+	attempts, err := p.retry.do(ctx, onRetry, func() error {
+		var err error
+		respBody, err = p.postOnce(ctx, url, contentType, p.compression, body)
+		return err
+	})
+	if err != nil && attempts > 1 {
+		err = &attemptsError{attempts: attempts, err: err}
+	}
+	if err == nil && p.stats != nil {
+		p.stats.wire(len(body))
+	}
+	return respBody, err
+}
+
+// query sends body to url once, uncompressed and without counting it in the
+// sink stats, for status requests such as HEC acknowledgement polls
+func (p *poster) query(ctx context.Context, url, contentType string, body []byte) ([]byte, error) {
+	return p.postOnce(ctx, url, contentType, "", body)
+}
+
+func (p *poster) postOnce(ctx context.Context, url, contentType, contentEncoding string, body []byte) ([]byte, error) {
+	if p.inFlight != nil {
+		select {
+		case p.inFlight <- struct{}{}:
+			defer func() { <-p.inFlight }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, permanentError{fmt.Errorf("creating request: %w", err)}
+	}
+	for key, vals := range p.header {
+		req.Header[key] = vals
+	}
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if p.sign != nil {
+		if err := p.sign(req, body); err != nil {
+			return nil, permanentError{fmt.Errorf("signing request: %w", err)}
+		}
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending logs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return respBody, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return respBody, nil
+}
+
+// httpSink posts each batch as a single JSON array, the format EasyLogs
+// ingests
+type httpSink struct {
+	counters
+	url    string
+	poster *poster
+}
+
+func newHTTPSink(cfg Config) (Sink, error) {
+	url := cfg.String("url", "")
+	if url == "" {
+		return nil, fmt.Errorf("option url is required")
+	}
+	p, err := newPoster(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &httpSink{url: url, poster: p}
+	p.stats = &s.counters
+	return s, nil
+}
+
+func (s *httpSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	body, err := json.Marshal(logs)
+	if err != nil {
+		s.failure(len(logs), err)
+		return fmt.Errorf("encoding log entries: %w", err)
+	}
+	if _, err := s.poster.post(ctx, s.url, "application/json", body); err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+	s.success(len(logs), len(body))
+	return nil
+}
+
+func (s *httpSink) Flush(ctx context.Context) error { return nil }
+
+func (s *httpSink) Close() error { return nil }
@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("kafka", newKafkaSink)
+}
+
+// kafkaSink produces each entry as a JSON message to one topic
+type kafkaSink struct {
+	counters
+	writer *kafka.Writer
+	key    string
+}
+
+// newKafkaSink reads:
+//
+//	brokers      comma separated bootstrap brokers (required)
+//	topic        target topic (required)
+//	key          entry field used as message key, e.g. service or user_id (default no key)
+//	acks         none, one or all (default all)
+//	compression  none, gzip, snappy, lz4 or zstd (default none)
+//	partitioner  hash, murmur2, crc32, roundrobin or leastbytes (default hash)
+//	batch_size   messages per produce request (default 1000)
+//	linger       how long a partial produce request waits for more messages (default 10ms)
+//	timeout      produce request timeout (default 10s)
+//	tls          connect to the brokers with TLS (default false)
+//
+// It also accepts the TLS options of readTLSOptions, which imply tls, and
+// the proxy option of readProxy.
+func newKafkaSink(cfg Config) (Sink, error) {
+	brokers := cfg.List("brokers", nil)
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("option brokers is required")
+	}
+	topic := cfg.String("topic", "")
+	if topic == "" {
+		return nil, fmt.Errorf("option topic is required")
+	}
+	key := cfg.String("key", "")
+	if key != "" {
+		if _, ok := entryField(logentry.LogEntry{}, key); !ok {
+			return nil, fmt.Errorf("option key: unknown field %q", key)
+		}
+	}
+
+	var acks kafka.RequiredAcks
+	if err := acks.UnmarshalText([]byte(cfg.String("acks", "all"))); err != nil {
+		return nil, fmt.Errorf("option acks: %w", err)
+	}
+	var compression kafka.Compression
+	if c := cfg.String("compression", "none"); c != "none" {
+		if err := compression.UnmarshalText([]byte(c)); err != nil {
+			return nil, fmt.Errorf("option compression: %w", err)
+		}
+	}
+
+	var balancer kafka.Balancer
+	switch p := cfg.String("partitioner", "hash"); p {
+	case "hash":
+		balancer = &kafka.Hash{}
+	case "murmur2":
+		balancer = kafka.Murmur2Balancer{}
+	case "crc32":
+		balancer = kafka.CRC32Balancer{}
+	case "roundrobin":
+		balancer = &kafka.RoundRobin{}
+	case "leastbytes":
+		balancer = &kafka.LeastBytes{}
+	default:
+		return nil, fmt.Errorf("option partitioner: unknown partitioner %q", p)
+	}
+
+	batchSize, err := cfg.Int("batch_size", 1000)
+	if err != nil {
+		return nil, err
+	}
+	linger, err := cfg.Duration("linger", 10*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := cfg.Duration("timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &kafka.Transport{DialTimeout: timeout}
+	useTLS, err := cfg.Bool("tls", false)
+	if err != nil {
+		return nil, err
+	}
+	tlsOpts, err := readTLSOptions(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if useTLS || tlsOpts.custom() {
+		// An empty server name is filled in with each broker's host
+		if transport.TLS, err = tlsOpts.config(""); err != nil {
+			return nil, err
+		}
+	}
+	proxyURL, _, err := readProxy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		transport.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialThrough(ctx, proxyURL, network, address)
+		}
+	}
+
+	return &kafkaSink{
+		key: key,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     balancer,
+			RequiredAcks: acks,
+			Compression:  compression,
+			BatchSize:    batchSize,
+			BatchTimeout: linger,
+			WriteTimeout: timeout,
+			ReadTimeout:  timeout,
+			Transport:    transport,
+		},
+	}, nil
+}
+
+func (s *kafkaSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	msgs := make([]kafka.Message, len(logs))
+	var size int
+	for i, entry := range logs {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			s.failure(len(logs), err)
+			return fmt.Errorf("encoding log entry: %w", err)
+		}
+		msgs[i] = kafka.Message{Value: value}
+		if s.key != "" {
+			k, _ := entryField(entry, s.key)
+			msgs[i].Key = []byte(k)
+		}
+		if entry.Generator != "" {
+			msgs[i].Headers = []kafka.Header{{Key: "generator", Value: []byte(entry.Generator)}}
+		}
+		size += len(value) + len(msgs[i].Key)
+	}
+
+	err := s.writer.WriteMessages(ctx, msgs...)
+	if err == nil {
+		s.success(len(logs), size)
+		return nil
+	}
+
+	// WriteErrors holds one error per message, nil for the ones delivered.
+	// Only a batch of which some messages were produced is a partial
+	// failure; one that failed entirely may be sent again as a whole.
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) && writeErrs.Count() < len(logs) {
+		partialErr := &PartialError{Total: len(logs)}
+		for i, writeErr := range writeErrs {
+			if writeErr != nil {
+				partialErr.Items = append(partialErr.Items, i)
+			}
+		}
+		partialErr.Failed = len(partialErr.Items)
+		partialErr.Err = fmt.Errorf("%d of %d messages not produced: %w", partialErr.Failed, len(logs), err)
+		s.partial(len(logs)-partialErr.Failed, partialErr.Failed, size, partialErr)
+		return partialErr
+	}
+	s.failure(len(logs), err)
+	return fmt.Errorf("producing to kafka: %w", err)
+}
+
+func (s *kafkaSink) Flush(ctx context.Context) error { return nil }
+
+func (s *kafkaSink) Close() error {
+	err := s.writer.Close()
+	s.writer.Transport.(*kafka.Transport).CloseIdleConnections()
+	return err
+}
@@ -0,0 +1,264 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("loki", newLokiSink)
+}
+
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiSink pushes batches to the Loki push API, grouping entries into streams
+// by the fields promoted to labels
+type lokiSink struct {
+	counters
+	url      string
+	labels   []string
+	static   map[string]string
+	protobuf bool
+	poster   *poster
+}
+
+// newLokiSink reads, in addition to the poster options:
+//
+//	url       Loki base URL or full push URL (required)
+//	labels    entry fields promoted to stream labels (default service,environment,level)
+//	job       value of the static job label (default log-generator, empty to omit)
+//	tenant    X-Scope-OrgID header for multi-tenant Loki
+//	encoding  protobuf (snappy compressed) or json (default protobuf)
+func newLokiSink(cfg Config) (Sink, error) {
+	url := strings.TrimRight(cfg.String("url", ""), "/")
+	if url == "" {
+		return nil, fmt.Errorf("option url is required")
+	}
+	if !strings.HasSuffix(url, lokiPushPath) {
+		url += lokiPushPath
+	}
+	labels := cfg.List("labels", []string{"service", "environment", "level"})
+	for _, label := range labels {
+		if _, ok := entryField(logentry.LogEntry{}, label); !ok {
+			return nil, fmt.Errorf("option labels: unknown field %q", label)
+		}
+	}
+	static := make(map[string]string)
+	if job, ok := cfg["job"]; !ok {
+		static["job"] = "log-generator"
+	} else if job != "" {
+		static["job"] = job
+	}
+	encoding := cfg.String("encoding", "protobuf")
+	if encoding != "protobuf" && encoding != "json" {
+		return nil, fmt.Errorf("option encoding must be protobuf or json, got %q", encoding)
+	}
+	if encoding == "protobuf" && cfg.String("compression", "none") != "none" {
+		return nil, fmt.Errorf("option compression needs encoding json, protobuf is already snappy compressed")
+	}
+	p, err := newPoster(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if tenant := cfg.String("tenant", ""); tenant != "" {
+		p.header.Set("X-Scope-OrgID", tenant)
+	}
+	s := &lokiSink{
+		url:      url,
+		labels:   labels,
+		static:   static,
+		protobuf: encoding == "protobuf",
+		poster:   p,
+	}
+	p.stats = &s.counters
+	return s, nil
+}
+
+// lokiStream is one label set and its entries in timestamp order
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+type lokiEntry struct {
+	ts   time.Time
+	line string
+}
+
+func (s *lokiSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	streams, err := s.streams(logs)
+	if err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+
+	var body []byte
+	var contentType string
+	if s.protobuf {
+		body = snappy.Encode(nil, encodeLokiProtobuf(streams))
+		contentType = "application/x-protobuf"
+	} else {
+		body, err = encodeLokiJSON(streams)
+		if err != nil {
+			s.failure(len(logs), err)
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	if _, err := s.poster.post(ctx, s.url, contentType, body); err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+	s.success(len(logs), len(body))
+	return nil
+}
+
+func (s *lokiSink) Flush(ctx context.Context) error { return nil }
+
+func (s *lokiSink) Close() error { return nil }
+
+// streams groups entries by label set. Promoted fields are removed from the
+// JSON line so they are not stored twice.
+func (s *lokiSink) streams(logs []logentry.LogEntry) ([]*lokiStream, error) {
+	byKey := make(map[string]*lokiStream)
+	var order []string
+	for _, entry := range logs {
+		labels := make(map[string]string, len(s.labels)+len(s.static))
+		for k, v := range s.static {
+			labels[k] = v
+		}
+		for _, name := range s.labels {
+			if v, _ := entryField(entry, name); v != "" {
+				labels[name] = v
+			}
+		}
+
+		fields, err := entryMap(entry)
+		if err != nil {
+			return nil, fmt.Errorf("encoding log entry: %w", err)
+		}
+		for _, name := range s.labels {
+			delete(fields, name)
+		}
+		delete(fields, "timestamp")
+		line, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("encoding log line: %w", err)
+		}
+
+		ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+		if err != nil {
+			ts = time.Now()
+		}
+
+		key := lokiLabelString(labels)
+		stream, ok := byKey[key]
+		if !ok {
+			stream = &lokiStream{labels: labels}
+			byKey[key] = stream
+			order = append(order, key)
+		}
+		stream.entries = append(stream.entries, lokiEntry{ts: ts, line: string(line)})
+	}
+
+	streams := make([]*lokiStream, 0, len(order))
+	for _, key := range order {
+		stream := byKey[key]
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].ts.Before(stream.entries[j].ts)
+		})
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// lokiLabelString renders labels in the {name="value", ...} form used by the
+// protobuf payload, with names sorted
+func lokiLabelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func encodeLokiJSON(streams []*lokiStream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	req := struct {
+		Streams []jsonStream `json:"streams"`
+	}{Streams: make([]jsonStream, 0, len(streams))}
+
+	for _, stream := range streams {
+		values := make([][2]string, len(stream.entries))
+		for i, e := range stream.entries {
+			values[i] = [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line}
+		}
+		req.Streams = append(req.Streams, jsonStream{Stream: stream.labels, Values: values})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encoding push request: %w", err)
+	}
+	return body, nil
+}
+
+// encodeLokiProtobuf writes a logproto.PushRequest:
+//
+//	PushRequest    { repeated StreamAdapter streams = 1; }
+//	StreamAdapter  { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter   { Timestamp timestamp = 1; string line = 2; }
+//	Timestamp      { int64 seconds = 1; int32 nanos = 2; }
+func encodeLokiProtobuf(streams []*lokiStream) []byte {
+	var req []byte
+	for _, stream := range streams {
+		var sa []byte
+		sa = protowire.AppendTag(sa, 1, protowire.BytesType)
+		sa = protowire.AppendString(sa, lokiLabelString(stream.labels))
+		for _, e := range stream.entries {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.ts.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.ts.Nanosecond()))
+
+			var ea []byte
+			ea = protowire.AppendTag(ea, 1, protowire.BytesType)
+			ea = protowire.AppendBytes(ea, ts)
+			ea = protowire.AppendTag(ea, 2, protowire.BytesType)
+			ea = protowire.AppendString(ea, e.line)
+
+			sa = protowire.AppendTag(sa, 2, protowire.BytesType)
+			sa = protowire.AppendBytes(sa, ea)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, sa)
+	}
+	return req
+}
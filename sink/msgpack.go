@@ -0,0 +1,292 @@
+package sink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// msgpackWriter appends MessagePack values to a byte slice. It covers the
+// types produced by decoding JSON plus the integer, binary and extension
+// types the Forward protocol needs.
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) nil() { w.buf = append(w.buf, 0xc0) }
+
+func (w *msgpackWriter) bool(v bool) {
+	if v {
+		w.buf = append(w.buf, 0xc3)
+	} else {
+		w.buf = append(w.buf, 0xc2)
+	}
+}
+
+func (w *msgpackWriter) int(v int64) {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		w.buf = append(w.buf, byte(v))
+	case v < 0 && v >= -32:
+		w.buf = append(w.buf, byte(v))
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		w.buf = append(w.buf, 0xd0, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		w.buf = append(w.buf, 0xd1)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		w.buf = append(w.buf, 0xd2)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(v))
+	default:
+		w.buf = append(w.buf, 0xd3)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(v))
+	}
+}
+
+func (w *msgpackWriter) float(v float64) {
+	w.buf = append(w.buf, 0xcb)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, math.Float64bits(v))
+}
+
+func (w *msgpackWriter) str(v string) {
+	n := len(v)
+	switch {
+	case n <= 31:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xda)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdb)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, v...)
+}
+
+func (w *msgpackWriter) bin(v []byte) {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xc5)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xc6)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, v...)
+}
+
+func (w *msgpackWriter) arrayHeader(n int) {
+	switch {
+	case n <= 15:
+		w.buf = append(w.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xdc)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdd)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) mapHeader(n int) {
+	switch {
+	case n <= 15:
+		w.buf = append(w.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xde)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdf)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+// ext8 writes a fixext 8 value, used by Fluent's EventTime (type 0)
+func (w *msgpackWriter) ext8(typ int8, data [8]byte) {
+	w.buf = append(w.buf, 0xd7, byte(typ))
+	w.buf = append(w.buf, data[:]...)
+}
+
+// value writes v, which must be one of the types encoding/json decodes into.
+// Whole float64 values are written as integers because JSON decoding turns
+// every integer field into a float64.
+func (w *msgpackWriter) value(v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		w.nil()
+	case bool:
+		w.bool(v)
+	case string:
+		w.str(v)
+	case int:
+		w.int(int64(v))
+	case int64:
+		w.int(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			w.int(int64(v))
+		} else {
+			w.float(v)
+		}
+	case []interface{}:
+		w.arrayHeader(len(v))
+		for _, item := range v {
+			if err := w.value(item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.mapHeader(len(keys))
+		for _, k := range keys {
+			w.str(k)
+			if err := w.value(v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+	return nil
+}
+
+// msgpackReadMap decodes a map with string keys whose values are strings,
+// integers, booleans or nil, which is all a Forward ack response contains. It
+// returns the number of bytes consumed, or 0 if b does not yet hold a
+// complete map.
+func msgpackReadMap(b []byte) (map[string]interface{}, int, error) {
+	r := msgpackReader{buf: b}
+	n, ok := r.mapLen()
+	if !ok {
+		return nil, 0, r.err
+	}
+	out := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, ok := r.scalar()
+		if !ok {
+			return nil, 0, r.err
+		}
+		val, ok := r.scalar()
+		if !ok {
+			return nil, 0, r.err
+		}
+		if k, isStr := key.(string); isStr {
+			out[k] = val
+		}
+	}
+	return out, r.pos, nil
+}
+
+type msgpackReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *msgpackReader) take(n int) ([]byte, bool) {
+	if r.pos+n > len(r.buf) {
+		return nil, false
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, true
+}
+
+func (r *msgpackReader) uint(size int) (uint64, bool) {
+	b, ok := r.take(size)
+	if !ok {
+		return 0, false
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+func (r *msgpackReader) mapLen() (int, bool) {
+	b, ok := r.take(1)
+	if !ok {
+		return 0, false
+	}
+	switch c := b[0]; {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f), true
+	case c == 0xde:
+		n, ok := r.uint(2)
+		return int(n), ok
+	case c == 0xdf:
+		n, ok := r.uint(4)
+		return int(n), ok
+	default:
+		r.err = fmt.Errorf("msgpack: expected map, got 0x%02x", c)
+		return 0, false
+	}
+}
+
+func (r *msgpackReader) scalar() (interface{}, bool) {
+	b, ok := r.take(1)
+	if !ok {
+		return nil, false
+	}
+	c := b[0]
+	strLen := -1
+	switch {
+	case c <= 0x7f:
+		return int64(c), true
+	case c >= 0xe0:
+		return int64(int8(c)), true
+	case c&0xe0 == 0xa0:
+		strLen = int(c & 0x1f)
+	case c == 0xc0:
+		return nil, true
+	case c == 0xc2:
+		return false, true
+	case c == 0xc3:
+		return true, true
+	case c == 0xd9 || c == 0xc4:
+		n, ok := r.uint(1)
+		if !ok {
+			return nil, false
+		}
+		strLen = int(n)
+	case c == 0xda || c == 0xc5:
+		n, ok := r.uint(2)
+		if !ok {
+			return nil, false
+		}
+		strLen = int(n)
+	case c == 0xdb || c == 0xc6:
+		n, ok := r.uint(4)
+		if !ok {
+			return nil, false
+		}
+		strLen = int(n)
+	case c >= 0xcc && c <= 0xcf:
+		v, ok := r.uint(1 << (c - 0xcc))
+		return int64(v), ok
+	case c >= 0xd0 && c <= 0xd3:
+		size := 1 << (c - 0xd0)
+		v, ok := r.uint(size)
+		shift := 64 - 8*size
+		return int64(v<<shift) >> shift, ok
+	default:
+		r.err = fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+		return nil, false
+	}
+	s, ok := r.take(strLen)
+	if !ok {
+		return nil, false
+	}
+	return string(s), true
+}
@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("opensearch", newOpenSearchSink)
+	Register("elasticsearch", newOpenSearchSink)
+}
+
+// openSearchSink writes batches through the _bulk API as action/source NDJSON
+// pairs
+type openSearchSink struct {
+	counters
+	url    string
+	action string
+	index  entryTemplate
+	poster *poster
+}
+
+// newOpenSearchSink reads, in addition to the poster options:
+//
+//	url       cluster base URL (required)
+//	index     index name template (default logs-{date:2006.01.02}), see entryTemplate
+//	action    bulk action, index or create (default index; data streams need create)
+//	api_key   API key, either base64 encoded or as id:key
+//
+// Setting aws_region signs every request with AWS SigV4 for Amazon OpenSearch
+// Service instead, see newSigV4Signer.
+func newOpenSearchSink(cfg Config) (Sink, error) {
+	url := strings.TrimRight(cfg.String("url", ""), "/")
+	if url == "" {
+		return nil, fmt.Errorf("option url is required")
+	}
+	action := cfg.String("action", "index")
+	if action != "index" && action != "create" {
+		return nil, fmt.Errorf("option action must be index or create, got %q", action)
+	}
+	index, err := parseEntryTemplate(cfg.String("index", "logs-{date:2006.01.02}"))
+	if err != nil {
+		return nil, err
+	}
+	p, err := newPoster(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if key := cfg.String("api_key", ""); key != "" {
+		if strings.Contains(key, ":") {
+			key = base64.StdEncoding.EncodeToString([]byte(key))
+		}
+		p.header.Set("Authorization", "ApiKey "+key)
+	}
+	if cfg.String("aws_region", "") != "" {
+		signer, err := newSigV4Signer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		p.header.Del("Authorization")
+		p.sign = signer.sign
+	}
+	s := &openSearchSink{url: url, action: action, index: index, poster: p}
+	p.stats = &s.counters
+	return s, nil
+}
+
+func (s *openSearchSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range logs {
+		action := map[string]map[string]string{
+			s.action: {"_index": s.index.render(entry)},
+		}
+		if err := enc.Encode(action); err != nil {
+			s.failure(len(logs), err)
+			return fmt.Errorf("encoding bulk action: %w", err)
+		}
+		if err := enc.Encode(entry); err != nil {
+			s.failure(len(logs), err)
+			return fmt.Errorf("encoding log entry: %w", err)
+		}
+	}
+
+	body := buf.Bytes()
+	respBody, err := s.poster.post(ctx, s.url+"/_bulk", "application/x-ndjson", body)
+	if err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+
+	var resp bulkResponse
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		err = fmt.Errorf("decoding bulk response: %w", err)
+		s.failure(len(logs), err)
+		return err
+	}
+	if !resp.Errors {
+		s.success(len(logs), len(body))
+		return nil
+	}
+
+	bulkErr := resp.rejections(len(logs))
+	s.partial(len(logs)-bulkErr.Rejected, bulkErr.Rejected, len(body), bulkErr)
+	return bulkErr
+}
+
+func (s *openSearchSink) Flush(ctx context.Context) error { return nil }
+
+func (s *openSearchSink) Close() error { return nil }
+
+// bulkResponse is the part of a _bulk response needed to find rejected items
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// BulkError reports the items of a _bulk request the cluster rejected while
+// accepting the rest
+type BulkError struct {
+	Total    int
+	Rejected int
+	// Reasons counts rejections by error type, with one sample reason each
+	Reasons map[string]BulkReason
+	// Items holds the positions of the rejected entries in the batch
+	Items []int
+}
+
+// BulkReason describes one kind of item rejection
+type BulkReason struct {
+	Count  int
+	Status int
+	Sample string
+}
+
+func (e *BulkError) Error() string {
+	types := make([]string, 0, len(e.Reasons))
+	for t := range e.Reasons {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		r := e.Reasons[t]
+		parts = append(parts, fmt.Sprintf("%dx %s (status %d): %s", r.Count, t, r.Status, r.Sample))
+	}
+	return fmt.Sprintf("%d of %d bulk items rejected: %s", e.Rejected, e.Total, strings.Join(parts, "; "))
+}
+
+func (r *bulkResponse) rejections(total int) *BulkError {
+	bulkErr := &BulkError{Total: total, Reasons: make(map[string]BulkReason)}
+	for i, item := range r.Items {
+		for _, result := range item {
+			if result.Status < 300 && result.Error == nil {
+				continue
+			}
+			bulkErr.Rejected++
+			bulkErr.Items = append(bulkErr.Items, i)
+			errType, sample := "unknown", ""
+			if result.Error != nil {
+				errType, sample = result.Error.Type, result.Error.Reason
+			}
+			reason := bulkErr.Reasons[errType]
+			reason.Count++
+			reason.Status = result.Status
+			if reason.Sample == "" {
+				reason.Sample = sample
+			}
+			bulkErr.Reasons[errType] = reason
+		}
+	}
+	return bulkErr
+}
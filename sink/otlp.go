@@ -0,0 +1,497 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("otlp", newOTLPSink)
+}
+
+const (
+	otlpLogsPath  = "/v1/logs"
+	otlpScopeName = "log-generator"
+)
+
+// otlpSink exports batches as OTLP LogRecords over HTTP, one ResourceLogs per
+// service and environment
+type otlpSink struct {
+	counters
+	url      string
+	protobuf bool
+	poster   *poster
+}
+
+// newOTLPSink reads, in addition to the poster options:
+//
+//	url       collector base URL or full /v1/logs URL (required)
+//	encoding  protobuf or json (default protobuf)
+func newOTLPSink(cfg Config) (Sink, error) {
+	url := strings.TrimRight(cfg.String("url", ""), "/")
+	if url == "" {
+		return nil, fmt.Errorf("option url is required")
+	}
+	if !strings.HasSuffix(url, otlpLogsPath) {
+		url += otlpLogsPath
+	}
+	encoding := cfg.String("encoding", "protobuf")
+	if encoding != "protobuf" && encoding != "json" {
+		return nil, fmt.Errorf("option encoding must be protobuf or json, got %q", encoding)
+	}
+	p, err := newPoster(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &otlpSink{url: url, protobuf: encoding == "protobuf", poster: p}
+	p.stats = &s.counters
+	return s, nil
+}
+
+func (s *otlpSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	resources := otlpResources(logs)
+
+	var body []byte
+	var contentType string
+	var err error
+	if s.protobuf {
+		body = encodeOTLPProtobuf(resources)
+		contentType = "application/x-protobuf"
+	} else {
+		body, err = encodeOTLPJSON(resources)
+		if err != nil {
+			s.failure(len(logs), err)
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	respBody, err := s.poster.post(ctx, s.url, contentType, body)
+	if err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+
+	rejected, msg := s.partialSuccess(respBody)
+	if rejected == 0 {
+		s.success(len(logs), len(body))
+		return nil
+	}
+	// The collector does not say which records it rejected
+	err = &PartialError{
+		Total:  len(logs),
+		Failed: int(rejected),
+		Err:    fmt.Errorf("%d of %d log records rejected: %s", rejected, len(logs), msg),
+	}
+	s.partial(len(logs)-int(rejected), int(rejected), len(body), err)
+	return err
+}
+
+func (s *otlpSink) Flush(ctx context.Context) error { return nil }
+
+func (s *otlpSink) Close() error { return nil }
+
+// partialSuccess reads ExportLogsPartialSuccess from a response. An empty or
+// unreadable body counts as full success, as the collector already answered
+// with a 2xx status.
+func (s *otlpSink) partialSuccess(body []byte) (int64, string) {
+	if len(body) == 0 {
+		return 0, ""
+	}
+	if !s.protobuf {
+		var resp struct {
+			PartialSuccess struct {
+				RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+				ErrorMessage       string      `json:"errorMessage"`
+			} `json:"partialSuccess"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return 0, ""
+		}
+		n, _ := resp.PartialSuccess.RejectedLogRecords.Int64()
+		return n, resp.PartialSuccess.ErrorMessage
+	}
+
+	// ExportLogsServiceResponse { ExportLogsPartialSuccess partial_success = 1; }
+	// ExportLogsPartialSuccess { int64 rejected_log_records = 1; string error_message = 2; }
+	var rejected int64
+	var msg string
+	walkProtobuf(body, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) {
+		if num != 1 || typ != protowire.BytesType {
+			return
+		}
+		walkProtobuf(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) {
+			switch {
+			case num == 1 && typ == protowire.VarintType:
+				rejected = int64(n)
+			case num == 2 && typ == protowire.BytesType:
+				msg = string(v)
+			}
+		})
+	})
+	return rejected, msg
+}
+
+// walkProtobuf calls fn for every top level field of a protobuf message,
+// passing varints in n and length delimited fields in v. It stops at the first
+// malformed field.
+func walkProtobuf(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64)) {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return
+		}
+		b = b[l:]
+		switch typ {
+		case protowire.VarintType:
+			n, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return
+			}
+			fn(num, typ, nil, n)
+			b = b[l:]
+		case protowire.BytesType:
+			v, l := protowire.ConsumeBytes(b)
+			if l < 0 {
+				return
+			}
+			fn(num, typ, v, 0)
+			b = b[l:]
+		default:
+			l := protowire.ConsumeFieldValue(num, typ, b)
+			if l < 0 {
+				return
+			}
+			b = b[l:]
+		}
+	}
+}
+
+// otlpResource is the ResourceLogs of one service and environment
+type otlpResource struct {
+	attributes []otlpKeyValue
+	records    []otlpRecord
+}
+
+type otlpRecord struct {
+	timeUnixNano   uint64
+	severityNumber int32
+	severityText   string
+	body           string
+	attributes     []otlpKeyValue
+}
+
+type otlpKeyValue struct {
+	key   string
+	value otlpValue
+}
+
+// otlpValue is an AnyValue. Exactly one of the fields is meaningful, chosen by
+// kind.
+type otlpValue struct {
+	kind   otlpKind
+	str    string
+	boolV  bool
+	intV   int64
+	double float64
+	list   []otlpValue
+	kvlist []otlpKeyValue
+}
+
+type otlpKind int
+
+const (
+	otlpString otlpKind = iota
+	otlpBool
+	otlpInt
+	otlpDouble
+	otlpArray
+	otlpKVList
+)
+
+// otlpSeverity maps generator levels onto OTLP SeverityNumber
+var otlpSeverity = map[string]int32{
+	"TRACE": 1,
+	"DEBUG": 5,
+	"INFO":  9,
+	"WARN":  13,
+	"ERROR": 17,
+	"FATAL": 21,
+}
+
+func otlpResources(logs []logentry.LogEntry) []*otlpResource {
+	byKey := make(map[[2]string]*otlpResource)
+	var order [][2]string
+	for _, entry := range logs {
+		key := [2]string{entry.Service, entry.Environment}
+		res, ok := byKey[key]
+		if !ok {
+			res = &otlpResource{attributes: []otlpKeyValue{
+				{key: "service.name", value: otlpValue{str: entry.Service}},
+				{key: "deployment.environment", value: otlpValue{str: entry.Environment}},
+			}}
+			byKey[key] = res
+			order = append(order, key)
+		}
+		res.records = append(res.records, otlpRecordFor(entry))
+	}
+
+	resources := make([]*otlpResource, len(order))
+	for i, key := range order {
+		resources[i] = byKey[key]
+	}
+	return resources
+}
+
+func otlpRecordFor(entry logentry.LogEntry) otlpRecord {
+	ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+	level := strings.ToUpper(entry.Level)
+
+	var attrs []otlpKeyValue
+	addString := func(key, v string) {
+		if v != "" {
+			attrs = append(attrs, otlpKeyValue{key: key, value: otlpValue{str: v}})
+		}
+	}
+	addInt := func(key string, v int) {
+		if v != 0 {
+			attrs = append(attrs, otlpKeyValue{key: key, value: otlpValue{kind: otlpInt, intV: int64(v)}})
+		}
+	}
+	addInt("http.response.status_code", entry.StatusCode)
+	addString("http.request.method", entry.Method)
+	addString("url.path", entry.Path)
+	addInt("duration_ms", entry.Duration)
+	addString("user.id", entry.UserID)
+	addString("action", entry.Action)
+	if entry.Metadata != nil {
+		if meta := otlpValueOf(entry.Metadata); meta.kind == otlpKVList {
+			attrs = append(attrs, meta.kvlist...)
+		} else {
+			attrs = append(attrs, otlpKeyValue{key: "metadata", value: meta})
+		}
+	}
+
+	return otlpRecord{
+		timeUnixNano:   uint64(ts.UnixNano()),
+		severityNumber: otlpSeverity[level],
+		severityText:   level,
+		body:           entry.Message,
+		attributes:     attrs,
+	}
+}
+
+// otlpValueOf converts metadata into an AnyValue, keeping integers as
+// intValue. Types it does not know are converted through their JSON form.
+func otlpValueOf(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case nil:
+		return otlpValue{}
+	case string:
+		return otlpValue{str: v}
+	case bool:
+		return otlpValue{kind: otlpBool, boolV: v}
+	case int:
+		return otlpValue{kind: otlpInt, intV: int64(v)}
+	case int32:
+		return otlpValue{kind: otlpInt, intV: int64(v)}
+	case int64:
+		return otlpValue{kind: otlpInt, intV: v}
+	case float32:
+		return otlpValue{kind: otlpDouble, double: float64(v)}
+	case float64:
+		return otlpValue{kind: otlpDouble, double: v}
+	case []interface{}:
+		list := make([]otlpValue, len(v))
+		for i, item := range v {
+			list[i] = otlpValueOf(item)
+		}
+		return otlpValue{kind: otlpArray, list: list}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]otlpKeyValue, len(keys))
+		for i, k := range keys {
+			kvs[i] = otlpKeyValue{key: k, value: otlpValueOf(v[k])}
+		}
+		return otlpValue{kind: otlpKVList, kvlist: kvs}
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return otlpValue{str: fmt.Sprint(v)}
+	}
+	var generic interface{}
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return otlpValue{str: string(raw)}
+	}
+	return otlpValueOf(generic)
+}
+
+// encodeOTLPProtobuf writes an ExportLogsServiceRequest, see
+// opentelemetry/proto/collector/logs/v1/logs_service.proto
+func encodeOTLPProtobuf(resources []*otlpResource) []byte {
+	var scope []byte
+	scope = protowire.AppendTag(scope, 1, protowire.BytesType)
+	scope = protowire.AppendString(scope, otlpScopeName)
+
+	var req []byte
+	for _, res := range resources {
+		var resource []byte
+		for _, kv := range res.attributes {
+			resource = appendOTLPMessage(resource, 1, appendOTLPKeyValue(nil, kv))
+		}
+
+		var scopeLogs []byte
+		scopeLogs = appendOTLPMessage(scopeLogs, 1, scope)
+		for _, rec := range res.records {
+			var lr []byte
+			lr = protowire.AppendTag(lr, 1, protowire.Fixed64Type)
+			lr = protowire.AppendFixed64(lr, rec.timeUnixNano)
+			if rec.severityNumber != 0 {
+				lr = protowire.AppendTag(lr, 2, protowire.VarintType)
+				lr = protowire.AppendVarint(lr, uint64(rec.severityNumber))
+			}
+			lr = protowire.AppendTag(lr, 3, protowire.BytesType)
+			lr = protowire.AppendString(lr, rec.severityText)
+			lr = appendOTLPMessage(lr, 5, appendOTLPValue(nil, otlpValue{str: rec.body}))
+			for _, kv := range rec.attributes {
+				lr = appendOTLPMessage(lr, 6, appendOTLPKeyValue(nil, kv))
+			}
+			lr = protowire.AppendTag(lr, 11, protowire.Fixed64Type)
+			lr = protowire.AppendFixed64(lr, rec.timeUnixNano)
+			scopeLogs = appendOTLPMessage(scopeLogs, 2, lr)
+		}
+
+		var resourceLogs []byte
+		resourceLogs = appendOTLPMessage(resourceLogs, 1, resource)
+		resourceLogs = appendOTLPMessage(resourceLogs, 2, scopeLogs)
+		req = appendOTLPMessage(req, 1, resourceLogs)
+	}
+	return req
+}
+
+func appendOTLPMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// appendOTLPKeyValue writes KeyValue { string key = 1; AnyValue value = 2; }
+func appendOTLPKeyValue(b []byte, kv otlpKeyValue) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, kv.key)
+	return appendOTLPMessage(b, 2, appendOTLPValue(nil, kv.value))
+}
+
+// appendOTLPValue writes the AnyValue oneof
+func appendOTLPValue(b []byte, v otlpValue) []byte {
+	switch v.kind {
+	case otlpBool:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v.boolV))
+	case otlpInt:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.intV))
+	case otlpDouble:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v.double))
+	case otlpArray:
+		var arr []byte
+		for _, item := range v.list {
+			arr = appendOTLPMessage(arr, 1, appendOTLPValue(nil, item))
+		}
+		b = appendOTLPMessage(b, 5, arr)
+	case otlpKVList:
+		var list []byte
+		for _, kv := range v.kvlist {
+			list = appendOTLPMessage(list, 1, appendOTLPKeyValue(nil, kv))
+		}
+		b = appendOTLPMessage(b, 6, list)
+	default:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, v.str)
+	}
+	return b
+}
+
+// encodeOTLPJSON writes the OTLP/JSON form of the request, which uses
+// lowerCamelCase field names and decimal strings for 64 bit integers
+func encodeOTLPJSON(resources []*otlpResource) ([]byte, error) {
+	type obj = map[string]interface{}
+
+	resourceLogs := make([]obj, 0, len(resources))
+	for _, res := range resources {
+		records := make([]obj, 0, len(res.records))
+		for _, rec := range res.records {
+			ts := strconv.FormatUint(rec.timeUnixNano, 10)
+			record := obj{
+				"timeUnixNano":         ts,
+				"observedTimeUnixNano": ts,
+				"severityText":         rec.severityText,
+				"body":                 otlpJSONValue(otlpValue{str: rec.body}),
+				"attributes":           otlpJSONKeyValues(rec.attributes),
+			}
+			if rec.severityNumber != 0 {
+				record["severityNumber"] = rec.severityNumber
+			}
+			records = append(records, record)
+		}
+		resourceLogs = append(resourceLogs, obj{
+			"resource": obj{"attributes": otlpJSONKeyValues(res.attributes)},
+			"scopeLogs": []obj{{
+				"scope":      obj{"name": otlpScopeName},
+				"logRecords": records,
+			}},
+		})
+	}
+
+	body, err := json.Marshal(obj{"resourceLogs": resourceLogs})
+	if err != nil {
+		return nil, fmt.Errorf("encoding OTLP request: %w", err)
+	}
+	return body, nil
+}
+
+func otlpJSONKeyValues(kvs []otlpKeyValue) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(kvs))
+	for i, kv := range kvs {
+		out[i] = map[string]interface{}{"key": kv.key, "value": otlpJSONValue(kv.value)}
+	}
+	return out
+}
+
+func otlpJSONValue(v otlpValue) map[string]interface{} {
+	switch v.kind {
+	case otlpBool:
+		return map[string]interface{}{"boolValue": v.boolV}
+	case otlpInt:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v.intV, 10)}
+	case otlpDouble:
+		return map[string]interface{}{"doubleValue": v.double}
+	case otlpArray:
+		values := make([]map[string]interface{}, len(v.list))
+		for i, item := range v.list {
+			values[i] = otlpJSONValue(item)
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case otlpKVList:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": otlpJSONKeyValues(v.kvlist)}}
+	}
+	return map[string]interface{}{"stringValue": v.str}
+}
@@ -0,0 +1,139 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// DefaultQueueSize is the number of batches a Pool buffers unless told
+// otherwise
+const DefaultQueueSize = 100
+
+// ErrQueueFull is reported to Pool.OnResult for batches dropped because the
+// queue was full
+var ErrQueueFull = errors.New("queue full")
+
+// poolOptions are the sender pool settings accepted by every sink spec
+var poolOptions = []string{"queue", "workers", "on_full"}
+
+// Pool decouples callers from a sink: Send only queues the batch and a fixed
+// number of workers deliver it, so a slow destination backs up the queue
+// instead of the generators
+type Pool struct {
+	sink    Sink
+	queue   chan []logentry.LogEntry
+	block   bool
+	workers sync.WaitGroup
+	// OnError is called from the workers when a batch fails. It may be nil
+	// and must be set before the first Send.
+	OnError func(err error)
+	// OnResult is called with how long every send took and its error, and
+	// with ErrQueueFull for every batch dropped because the queue was full.
+	// It may be nil and must be set before the first Send.
+	OnResult func(took time.Duration, err error)
+
+	inFlight     atomic.Int64 // batches queued or being sent
+	queueDropped atomic.Int64 // entries dropped because the queue was full
+	closeOnce    sync.Once
+}
+
+// NewPool starts workers goroutines sending to s from a queue of queueSize
+// batches. With block set, Send waits for queue space instead of dropping
+// the batch.
+func NewPool(s Sink, queueSize, workers int, block bool) *Pool {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		sink:  s,
+		queue: make(chan []logentry.LogEntry, queueSize),
+		block: block,
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *Pool) work() {
+	defer p.workers.Done()
+	for logs := range p.queue {
+		start := time.Now()
+		err := p.sink.Send(context.Background(), logs)
+		if p.OnResult != nil {
+			p.OnResult(time.Since(start), err)
+		}
+		if err != nil && p.OnError != nil {
+			p.OnError(err)
+		}
+		p.inFlight.Add(-1)
+	}
+}
+
+// Send queues logs. When the queue is full the batch is dropped and counted,
+// unless the pool blocks.
+func (p *Pool) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	p.inFlight.Add(1)
+	if p.block {
+		select {
+		case p.queue <- logs:
+			return nil
+		case <-ctx.Done():
+			p.inFlight.Add(-1)
+			return ctx.Err()
+		}
+	}
+	select {
+	case p.queue <- logs:
+	default:
+		p.inFlight.Add(-1)
+		p.queueDropped.Add(int64(len(logs)))
+		if p.OnResult != nil {
+			p.OnResult(0, ErrQueueFull)
+		}
+	}
+	return nil
+}
+
+// Flush waits until every queued batch has been sent, then flushes the sink
+func (p *Pool) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for p.inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return p.sink.Flush(ctx)
+}
+
+// Close drains the queue and closes the sink. Send must not be called
+// afterwards.
+func (p *Pool) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.queue)
+		p.workers.Wait()
+		err = p.sink.Close()
+	})
+	return err
+}
+
+// Stats reports the counters of the sink with the queue state
+func (p *Pool) Stats() Stats {
+	s := p.sink.Stats()
+	s.Queued = int64(len(p.queue))
+	s.QueueDropped = p.queueDropped.Load()
+	return s
+}
@@ -0,0 +1,204 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed request is sent again
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt, so 1 disables retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the second attempt. Each further
+	// wait is Multiplier times longer, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomizes each wait by up to this fraction in either direction
+	Jitter float64
+	// MaxElapsed bounds the total time spent on one batch, including
+	// waits. Zero means no bound.
+	MaxElapsed time.Duration
+}
+
+// newRetryPolicy reads:
+//
+//	max_attempts  attempts per batch including the first, 1 disables retries (default 3)
+//	backoff       wait before the first retry (default 200ms)
+//	max_backoff   longest wait between attempts (default 10s)
+//	max_elapsed   total time budget per batch (default 30s)
+func newRetryPolicy(cfg Config) (RetryPolicy, error) {
+	p := RetryPolicy{Multiplier: 2, Jitter: 0.2}
+	var err error
+	if p.MaxAttempts, err = cfg.Int("max_attempts", 3); err != nil {
+		return p, err
+	}
+	if p.InitialBackoff, err = cfg.Duration("backoff", 200*time.Millisecond); err != nil {
+		return p, err
+	}
+	if p.MaxBackoff, err = cfg.Duration("max_backoff", 10*time.Second); err != nil {
+		return p, err
+	}
+	if p.MaxElapsed, err = cfg.Duration("max_elapsed", 30*time.Second); err != nil {
+		return p, err
+	}
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	return p, nil
+}
+
+// permanentError marks a failure that no retry can fix, such as a request
+// that cannot be built
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// PartialError reports a batch the destination accepted in part. Sending
+// the batch again would duplicate the accepted entries, so the spool and
+// dead-letter wrappers act on the failed entries only.
+type PartialError struct {
+	Total int
+	// Failed counts the entries that were not delivered
+	Failed int
+	// Items holds the positions of the failed entries in the batch. It is
+	// nil when the destination only reported how many failed.
+	Items []int
+	// Retry is set when the failed entries were left unsent rather than
+	// rejected, so that sending only them again may succeed
+	Retry bool
+	Err   error
+}
+
+func (e *PartialError) Error() string { return e.Err.Error() }
+
+func (e *PartialError) Unwrap() error { return e.Err }
+
+// asPartial returns the PartialError in err's chain, presenting a
+// *BulkError as one
+func asPartial(err error) (*PartialError, bool) {
+	var partialErr *PartialError
+	if errors.As(err, &partialErr) {
+		return partialErr, true
+	}
+	var bulkErr *BulkError
+	if errors.As(err, &bulkErr) {
+		return &PartialError{Total: bulkErr.Total, Failed: bulkErr.Rejected, Items: bulkErr.Items, Err: bulkErr}, true
+	}
+	return nil, false
+}
+
+// attemptsError reports a request that failed after being sent more than
+// once
+type attemptsError struct {
+	attempts int
+	err      error
+}
+
+func (e *attemptsError) Error() string {
+	return fmt.Sprintf("after %d attempts: %s", e.attempts, e.err)
+}
+
+func (e *attemptsError) Unwrap() error { return e.err }
+
+// attempts returns how often the batch that failed with err was sent
+func attempts(err error) int {
+	var attemptsErr *attemptsError
+	if errors.As(err, &attemptsErr) {
+		return attemptsErr.attempts
+	}
+	return 1
+}
+
+// Retryable reports whether err may succeed when sent again: transport
+// errors, 429 and 5xx responses. Other 4xx responses are never retried, and
+// neither is a canceled context or a batch the destination accepted in
+// part, unless the rest of it was left unsent; see PartialError.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.As(err, new(permanentError)) {
+		return false
+	}
+	if partialErr, ok := asPartial(err); ok {
+		return partialErr.Retry
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// backoff returns the wait before attempt number next (2 for the first
+// retry). A Retry-After from the server takes precedence over the computed
+// backoff.
+func (p RetryPolicy) backoff(next int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+
+	d := float64(p.InitialBackoff)
+	for i := 2; i < next; i++ {
+		d *= p.Multiplier
+		if d >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if max := float64(p.MaxBackoff); max > 0 && d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// do calls attempt until it succeeds, fails with an error that is not
+// retryable, or the attempt or time budget runs out. onRetry is called
+// before every retry.
+func (p RetryPolicy) do(ctx context.Context, onRetry func(), attempt func() error) (int, error) {
+	start := time.Now()
+	for n := 1; ; n++ {
+		err := attempt()
+		if err == nil || n >= p.MaxAttempts || !Retryable(err) {
+			return n, err
+		}
+
+		wait := p.backoff(n+1, err)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return n, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return n, err
+		}
+		if onRetry != nil {
+			onRetry()
+		}
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
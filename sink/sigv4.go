@@ -0,0 +1,261 @@
+package sink
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+)
+
+// awsCredentials are the keys requests are signed with
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// sigV4Signer signs requests with AWS Signature Version 4, as Amazon
+// OpenSearch Service requires when a domain uses IAM access policies
+type sigV4Signer struct {
+	region  string
+	service string
+	creds   awsCredentials
+	now     func() time.Time
+}
+
+// newSigV4Signer reads:
+//
+//	aws_region             region of the domain, enables signing (required)
+//	aws_service            es for OpenSearch domains, aoss for Serverless collections (default es)
+//	aws_access_key_id      static access key, with aws_secret_access_key
+//	aws_secret_access_key  static secret key
+//	aws_session_token      session token of temporary static credentials
+//	aws_profile            profile of the shared credentials file (default AWS_PROFILE or default)
+//
+// Credentials come from the static options, else from AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, else from the shared
+// credentials file at AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials.
+func newSigV4Signer(cfg Config) (*sigV4Signer, error) {
+	s := &sigV4Signer{
+		region:  cfg.String("aws_region", ""),
+		service: cfg.String("aws_service", "es"),
+		now:     time.Now,
+	}
+	if s.region == "" {
+		return nil, fmt.Errorf("option aws_region is required for signing")
+	}
+	creds, err := loadAWSCredentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.creds = creds
+	return s, nil
+}
+
+// loadAWSCredentials resolves credentials in the order documented on
+// newSigV4Signer
+func loadAWSCredentials(cfg Config) (awsCredentials, error) {
+	if id := cfg.String("aws_access_key_id", ""); id != "" {
+		secret := cfg.String("aws_secret_access_key", "")
+		if secret == "" {
+			return awsCredentials{}, fmt.Errorf("option aws_access_key_id needs aws_secret_access_key")
+		}
+		return awsCredentials{id, secret, cfg.String("aws_session_token", "")}, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+		if secret == "" {
+			return awsCredentials{}, fmt.Errorf("AWS_ACCESS_KEY_ID is set without AWS_SECRET_ACCESS_KEY")
+		}
+		return awsCredentials{id, secret, os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("no AWS credentials in the spec or environment, and no home directory: %w", err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := cfg.String("aws_profile", os.Getenv("AWS_PROFILE"))
+	if profile == "" {
+		profile = "default"
+	}
+	return readSharedCredentials(path, profile)
+}
+
+// readSharedCredentials reads one profile of an AWS shared credentials file
+func readSharedCredentials(path, profile string) (awsCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("no AWS credentials in the spec or environment: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	var section string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		if key, val, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(val)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return awsCredentials{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	creds := awsCredentials{
+		accessKeyID:     values["aws_access_key_id"],
+		secretAccessKey: values["aws_secret_access_key"],
+		sessionToken:    values["aws_session_token"],
+	}
+	if creds.accessKeyID == "" || creds.secretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("profile %s in %s has no aws_access_key_id and aws_secret_access_key", profile, path)
+	}
+	return creds, nil
+}
+
+// sign adds the X-Amz-* headers and the Authorization header for body, which
+// must be exactly what the request sends
+func (s *sigV4Signer) sign(req *http.Request, body []byte) error {
+	now := s.now().UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.creds.sessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, vals := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || lower == "content-encoding" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.Join(strings.Fields(strings.Join(vals, ",")), " ")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4Path(req.URL),
+		sigV4Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/" + s.service + "/aws4_request"
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.creds.secretAccessKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.creds.accessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// sigV4Path returns the canonical URI: every segment of the escaped path is
+// escaped once more, as services other than S3 expect
+func sigV4Path(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		segments[i] = sigV4Escape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4Query returns the canonical query string, sorted by key and value
+func sigV4Query(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return sigV4Escape(keys[i]) < sigV4Escape(keys[j]) })
+	var pairs []string
+	for _, key := range keys {
+		vals := make([]string, len(values[key]))
+		for i, val := range values[key] {
+			vals[i] = sigV4Escape(val)
+		}
+		sort.Strings(vals)
+		for _, val := range vals {
+			pairs = append(pairs, sigV4Escape(key)+"="+val)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape percent-encodes everything but the RFC 3986 unreserved
+// characters
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,214 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// The expected signatures were produced by the v4 signer of aws-sdk-go-v2
+// for the same requests, credentials and time, with X-Amz-Content-Sha256 set
+// as the sink sets it
+func TestSigV4Sign(t *testing.T) {
+	const prefix = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240517/eu-west-1/"
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		service string
+		token   string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "bulk",
+			method:  http.MethodPost,
+			url:     "https://search-logs.eu-west-1.es.amazonaws.com/_bulk",
+			body:    "{\"index\":{}}\n{\"message\":\"a\"}\n",
+			service: "es",
+			headers: map[string]string{"Content-Type": "application/x-ndjson"},
+			want: prefix + "es/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, " +
+				"Signature=396a66936c9954f9f9a26fc5f51f40aac3bd01edefab1a8926b3bd8dae8e13ef",
+		},
+		{
+			name:    "session token",
+			method:  http.MethodPost,
+			url:     "https://search-logs.eu-west-1.es.amazonaws.com/_bulk",
+			body:    "{\"index\":{}}\n{\"message\":\"a\"}\n",
+			service: "es",
+			token:   "IQoJb3JpZ2luX2VjEXAMPLE/TOKEN+=",
+			headers: map[string]string{"Content-Type": "application/x-ndjson"},
+			want: prefix + "es/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token, " +
+				"Signature=72ab1a63ec60c32e8412811c584964c64facc5c5a75fef48e4938cfb715d2fb7",
+		},
+		{
+			name:    "query string",
+			method:  http.MethodGet,
+			url:     "https://search-logs.eu-west-1.es.amazonaws.com/logs-2024.05.17/_search?size=10&q=level%3AERROR%20now&b=2&b=1&A=x~y",
+			service: "es",
+			want: prefix + "es/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+				"Signature=fd0a615a3912813001ce42ad1a350c0e06a7002d5a2ef42716a67a997a8741d0",
+		},
+		{
+			name:    "escaped path",
+			method:  http.MethodPut,
+			url:     "https://search-logs.eu-west-1.es.amazonaws.com/my%20index/_doc/a%2Fb",
+			body:    "{}",
+			service: "es",
+			headers: map[string]string{"Content-Type": "application/json"},
+			want: prefix + "es/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, " +
+				"Signature=c2a6ed93245155d630f6d2786f6ba8656aaecbc1af881eaa62e781f4d73a2b78",
+		},
+		{
+			name:    "compressed serverless",
+			method:  http.MethodPost,
+			url:     "http://127.0.0.1:9200/_bulk",
+			body:    "gzipped",
+			service: "aoss",
+			headers: map[string]string{"Content-Type": "application/x-ndjson", "Content-Encoding": "gzip"},
+			want: prefix + "aoss/aws4_request, SignedHeaders=content-encoding;content-type;host;x-amz-content-sha256;x-amz-date, " +
+				"Signature=e211ef0f4d352728f57898f88c380235c7ead37b6af9db684b5f464ef22d9713",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := &sigV4Signer{
+				region:  "eu-west-1",
+				service: tt.service,
+				creds:   awsCredentials{"AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", tt.token},
+				now:     func() time.Time { return time.Date(2024, 5, 17, 10, 20, 30, 0, time.UTC) },
+			}
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, val := range tt.headers {
+				req.Header.Set(name, val)
+			}
+			// Headers outside the signed set must not change the signature
+			req.Header.Set("User-Agent", "log-generator")
+
+			if err := signer.sign(req, []byte(tt.body)); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Authorization\n got %s\nwant %s", got, tt.want)
+			}
+			if got := req.Header.Get("X-Amz-Date"); got != "20240517T102030Z" {
+				t.Errorf("X-Amz-Date = %q", got)
+			}
+			if got := req.Header.Get("X-Amz-Content-Sha256"); got != sha256Hex([]byte(tt.body)) {
+				t.Errorf("X-Amz-Content-Sha256 = %q", got)
+			}
+			if got := req.Header.Get("X-Amz-Security-Token"); got != tt.token {
+				t.Errorf("X-Amz-Security-Token = %q, want %q", got, tt.token)
+			}
+		})
+	}
+}
+
+// Canonical query strings from the AWS SigV4 test suite
+func TestSigV4Query(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"get-vanilla-query-order-key-case", "Param2=value2&Param1=value1", "Param1=value1&Param2=value2"},
+		{"get-vanilla-query-order-value", "Param1=value2&Param1=Value1", "Param1=Value1&Param1=value2"},
+		{"get-vanilla-query-unreserved",
+			"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			"-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"},
+		{"get-vanilla-utf8-query", "%E1%88%B4=bar", "%E1%88%B4=bar"},
+		{"space and reserved", "q=a%20b%2Bc&empty=", "empty=&q=a%20b%2Bc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sigV4Query(values); got != tt.want {
+				t.Errorf("sigV4Query(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSigV4Path(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/_bulk", "/_bulk"},
+		// Services other than S3 expect the escaped path escaped once more
+		{"/my%20index/_doc", "/my%2520index/_doc"},
+		{"/logs/_doc/a%2Fb", "/logs/_doc/a%252Fb"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse("https://example.com" + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sigV4Path(u); got != tt.want {
+			t.Errorf("sigV4Path(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// The OpenSearch sink signs what it actually sends: the stand-in checks the
+// payload hash against the compressed body it received and that the
+// signature covers the session token
+func TestOpenSearchSigned(t *testing.T) {
+	var gotAuth, gotHash, wantHash, gotToken string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotAuth = r.Header.Get("Authorization")
+		gotHash = r.Header.Get("X-Amz-Content-Sha256")
+		gotToken = r.Header.Get("X-Amz-Security-Token")
+		wantHash = sha256Hex(body)
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer srv.Close()
+
+	s, err := New("opensearch", Config{
+		"url":                   srv.URL,
+		"aws_region":            "eu-west-1",
+		"aws_access_key_id":     "AKIDEXAMPLE",
+		"aws_secret_access_key": "secret",
+		"aws_session_token":     "token",
+		"username":              "ignored",
+		"password":              "ignored",
+		"compression":           "gzip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Send(context.Background(), []logentry.LogEntry{{Message: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		t.Errorf("Authorization = %q, want a SigV4 signature replacing Basic auth", gotAuth)
+	}
+	if !strings.Contains(gotAuth, "/eu-west-1/es/aws4_request, SignedHeaders=content-encoding;content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token, ") {
+		t.Errorf("Authorization = %q, want scope and signed headers of a compressed request with a session token", gotAuth)
+	}
+	if gotHash != wantHash {
+		t.Errorf("X-Amz-Content-Sha256 = %q, want the hash of the body on the wire %q", gotHash, wantHash)
+	}
+	if gotToken != "token" {
+		t.Errorf("X-Amz-Security-Token = %q", gotToken)
+	}
+}
@@ -0,0 +1,420 @@
+// Package sink delivers batches of generated log entries to a destination.
+//
+// Destinations register themselves by name so that callers can pick one from
+// a spec string such as
+//
+//	http?url=https://ingestion.easylogs.co/logs&token=XXXX
+//
+// instead of editing constants and recompiling.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// Sink is a destination for generated log entries
+type Sink interface {
+	// Send delivers a batch. Implementations may buffer it until Flush.
+	Send(ctx context.Context, logs []logentry.LogEntry) error
+	// Flush delivers anything buffered by earlier Send calls.
+	Flush(ctx context.Context) error
+	// Close flushes and releases connections and files.
+	Close() error
+	// Stats reports delivery counters since the sink was created.
+	Stats() Stats
+}
+
+// Stats is a snapshot of a sink's delivery counters
+type Stats struct {
+	Batches  int64 `json:"batches"`
+	Entries  int64 `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	Failures int64 `json:"failures"`
+	Dropped  int64 `json:"dropped"`
+	Retries  int64 `json:"retries"`
+	// WireBytes is what HTTP sinks sent in successful requests after
+	// compression, while Bytes counts the batches before it.
+	WireBytes int64 `json:"wire_bytes,omitempty"`
+	// Spooled and SpoolBytes are the entries and bytes waiting in the disk
+	// spool, for sinks configured with one
+	Spooled    int64 `json:"spooled,omitempty"`
+	SpoolBytes int64 `json:"spool_bytes,omitempty"`
+	// Queued and QueueDropped are the batches waiting in a Pool and the
+	// entries it dropped because its queue was full
+	Queued       int64 `json:"queued,omitempty"`
+	QueueDropped int64 `json:"queue_dropped,omitempty"`
+	// DeadLettered counts the entries written to the dead-letter file
+	DeadLettered int64 `json:"dead_lettered,omitempty"`
+	// Breaker is the circuit breaker state, BreakerClosed, BreakerOpen or
+	// BreakerHalfOpen, for sinks configured with one. BreakerOpens counts
+	// how often it opened.
+	Breaker      string `json:"breaker,omitempty"`
+	BreakerOpens int64  `json:"breaker_opens,omitempty"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+func (s Stats) String() string {
+	out := fmt.Sprintf("batches=%d entries=%d bytes=%d failures=%d dropped=%d retries=%d",
+		s.Batches, s.Entries, s.Bytes, s.Failures, s.Dropped, s.Retries)
+	if s.WireBytes > 0 {
+		out += fmt.Sprintf(" wire_bytes=%d", s.WireBytes)
+	}
+	if s.Spooled > 0 || s.SpoolBytes > 0 {
+		out += fmt.Sprintf(" spooled=%d spool_bytes=%d", s.Spooled, s.SpoolBytes)
+	}
+	if s.Queued > 0 || s.QueueDropped > 0 {
+		out += fmt.Sprintf(" queued=%d queue_dropped=%d", s.Queued, s.QueueDropped)
+	}
+	if s.DeadLettered > 0 {
+		out += fmt.Sprintf(" dead_lettered=%d", s.DeadLettered)
+	}
+	if s.Breaker != "" {
+		out += fmt.Sprintf(" breaker=%s breaker_opens=%d", s.Breaker, s.BreakerOpens)
+	}
+	if s.LastError != "" {
+		out += " last_error=" + strconv.Quote(s.LastError)
+	}
+	return out
+}
+
+// counters is embedded by sinks to implement Stats
+type counters struct {
+	batches   atomic.Int64
+	entries   atomic.Int64
+	bytes     atomic.Int64
+	failures  atomic.Int64
+	dropped   atomic.Int64
+	retries   atomic.Int64
+	wireBytes atomic.Int64
+	lastErr   atomic.Value
+}
+
+func (c *counters) success(entries, bytes int) {
+	c.batches.Add(1)
+	c.entries.Add(int64(entries))
+	c.bytes.Add(int64(bytes))
+}
+
+func (c *counters) failure(entries int, err error) {
+	c.failures.Add(1)
+	c.dropped.Add(int64(entries))
+	if err != nil {
+		c.lastErr.Store(err.Error())
+	}
+}
+
+// partial records a batch the destination accepted only in part
+func (c *counters) partial(accepted, rejected, bytes int, err error) {
+	c.success(accepted, bytes)
+	c.dropped.Add(int64(rejected))
+	if err != nil {
+		c.lastErr.Store(err.Error())
+	}
+}
+
+// retried records a request sent again after a retryable failure
+func (c *counters) retried() {
+	c.retries.Add(1)
+}
+
+// wire records the bytes an HTTP request carried after compression
+func (c *counters) wire(bytes int) {
+	c.wireBytes.Add(int64(bytes))
+}
+
+func (c *counters) Stats() Stats {
+	s := Stats{
+		Batches:   c.batches.Load(),
+		Entries:   c.entries.Load(),
+		Bytes:     c.bytes.Load(),
+		Failures:  c.failures.Load(),
+		Dropped:   c.dropped.Load(),
+		Retries:   c.retries.Load(),
+		WireBytes: c.wireBytes.Load(),
+	}
+	if v, ok := c.lastErr.Load().(string); ok {
+		s.LastError = v
+	}
+	return s
+}
+
+// entryField returns the string fields of an entry by their JSON name:
+// level, service, environment, action, method and user_id, plus generator
+func entryField(entry logentry.LogEntry, name string) (string, bool) {
+	switch name {
+	case "level":
+		return entry.Level, true
+	case "service":
+		return entry.Service, true
+	case "environment":
+		return entry.Environment, true
+	case "action":
+		return entry.Action, true
+	case "method":
+		return entry.Method, true
+	case "user_id":
+		return entry.UserID, true
+	case "generator":
+		return entry.Generator, true
+	}
+	return "", false
+}
+
+// entryMap returns an entry as the map of its JSON fields
+func entryMap(entry logentry.LogEntry) (map[string]interface{}, error) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// Config holds the key/value options of a sink spec
+type Config map[string]string
+
+// String returns the option or def when it is unset
+func (c Config) String(key, def string) string {
+	if v, ok := c[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns the option parsed as an integer or def when it is unset
+func (c Config) Int(key string, def int) (int, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// Float returns the option parsed as a floating point number or def when it
+// is unset
+func (c Config) Float(key string, def float64) (float64, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %w", key, err)
+	}
+	return f, nil
+}
+
+// Bool returns the option parsed as a boolean or def when it is unset
+func (c Config) Bool(key string, def bool) (bool, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("option %s: %w", key, err)
+	}
+	return b, nil
+}
+
+// Duration returns the option parsed with time.ParseDuration or def when it
+// is unset
+func (c Config) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("option %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// Size returns the option as a byte count. It accepts a plain number or one
+// with a KB, MB or GB suffix (powers of 1024).
+func (c Config) Size(key string, def int64) (int64, error) {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return def, nil
+	}
+	mult := int64(1)
+	upper := strings.ToUpper(strings.TrimSpace(v))
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			mult = unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("option %s: invalid size %q", key, v)
+	}
+	return n * mult, nil
+}
+
+// List returns a comma separated option split into its trimmed elements
+func (c Config) List(key string, def []string) []string {
+	v, ok := c[key]
+	if !ok || v == "" {
+		return def
+	}
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// Factory builds a sink from its options
+type Factory func(cfg Config) (Sink, error)
+
+var (
+	registryMux sync.RWMutex
+	registry    = make(map[string]Factory)
+)
+
+// Register makes a sink available under name. It panics if name is already
+// taken, as two sinks claiming one name is a programming error.
+func Register(name string, factory Factory) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+
+	if _, dup := registry[name]; dup {
+		panic("sink: Register called twice for " + name)
+	}
+	registry[name] = factory
+}
+
+// Names lists the registered sink names in sorted order
+func Names() []string {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New builds the sink registered under name. Any sink can be given a disk
+// spool with the spool options, see takeSpool, have its batches regrouped
+// with the batching options, see takeBatch, record the batches it rejects
+// for good with the dead-letter option, see takeDeadLetter, and stop sending
+// while it keeps failing with the circuit breaker options, see takeBreaker.
+// Batching happens before the spool, so the spool stores and replays the
+// final batches, and the dead-letter file receives what the spool could not
+// deliver. The breaker sits closest to the destination, so a spool holds
+// the batches it refuses.
+func New(name string, cfg Config) (Sink, error) {
+	registryMux.RLock()
+	factory, ok := registry[name]
+	registryMux.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	if cfg == nil {
+		cfg = Config{}
+	}
+	spoolOpts, err := takeSpool(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %w", name, err)
+	}
+	batchOpts := takeBatch(cfg)
+	deadLetterPath := takeDeadLetter(cfg)
+	breakerOpts, err := takeBreaker(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %w", name, err)
+	}
+	s, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: %w", name, err)
+	}
+	if breakerOpts != nil {
+		guarded, err := newBreaker(s, breakerOpts)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		s = guarded
+	}
+	if deadLetterPath != "" {
+		recorded, err := newDeadLetter(s, name, deadLetterPath)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		s = recorded
+	}
+	if spoolOpts != nil {
+		spooled, err := newSpool(s, spoolOpts)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		s = spooled
+	}
+	if batchOpts != nil {
+		batched, err := newBatcher(s, batchOpts)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		s = batched
+	}
+	return s, nil
+}
+
+// ParseSpec splits a spec of the form name?key=value&key=value into the
+// sink name and its options. Values use URL query escaping.
+func ParseSpec(spec string) (string, Config, error) {
+	name, query, _ := strings.Cut(strings.TrimSpace(spec), "?")
+	if name == "" {
+		return "", nil, fmt.Errorf("sink spec %q has no name", spec)
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("sink spec %q: %w", spec, err)
+	}
+	cfg := make(Config, len(values))
+	for key, vals := range values {
+		cfg[key] = vals[len(vals)-1]
+	}
+	return name, cfg, nil
+}
+
+// Open parses spec and builds the sink it names
+func Open(spec string) (Sink, error) {
+	name, cfg, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return New(name, cfg)
+}
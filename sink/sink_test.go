@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"context"
+	"sync"
+
+	"log-generator/logentry"
+)
+
+// fakeSink records the batches it is sent. fail, if set, decides the
+// outcome of every Send before the batch is recorded.
+type fakeSink struct {
+	counters
+	fail func(logs []logentry.LogEntry) error
+
+	mu      sync.Mutex
+	batches [][]logentry.LogEntry
+	sends   int
+}
+
+func (f *fakeSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sends++
+	if f.fail != nil {
+		if err := f.fail(logs); err != nil {
+			return err
+		}
+	}
+	f.batches = append(f.batches, append([]logentry.LogEntry(nil), logs...))
+	f.success(len(logs), 0)
+	return nil
+}
+
+func (f *fakeSink) Flush(ctx context.Context) error { return nil }
+
+func (f *fakeSink) Close() error { return nil }
+
+// messages returns the messages of every recorded entry in order
+func (f *fakeSink) messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var out []string
+	for _, batch := range f.batches {
+		for _, entry := range batch {
+			out = append(out, entry.Message)
+		}
+	}
+	return out
+}
+
+// entries returns one entry per message
+func entries(messages ...string) []logentry.LogEntry {
+	logs := make([]logentry.LogEntry, len(messages))
+	for i, msg := range messages {
+		logs[i] = logentry.LogEntry{Message: msg}
+	}
+	return logs
+}
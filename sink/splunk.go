@@ -0,0 +1,326 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("splunk", newSplunkSink)
+}
+
+// splunkSink sends batches to a Splunk HTTP Event Collector. With acks
+// enabled a batch only counts as delivered once HEC reports it indexed.
+type splunkSink struct {
+	counters
+	url        string
+	raw        bool
+	host       string
+	source     string
+	sourcetype string
+	index      string
+	poster     *poster
+
+	ack         bool
+	ackURL      string
+	ackTimeout  time.Duration
+	ackInterval time.Duration
+	pendingMux  sync.Mutex
+	pending     map[int64]pendingAck
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// pendingAck is a batch HEC accepted but has not yet reported as indexed
+type pendingAck struct {
+	entries int
+	bytes   int
+	sent    time.Time
+}
+
+// newSplunkSink reads, in addition to the poster options:
+//
+//	url           HEC base URL, e.g. https://splunk:8088 (required)
+//	token         HEC token, sent as Authorization: Splunk <token> (required)
+//	endpoint      event or raw (default event)
+//	host          event host (default the local hostname)
+//	source        event source (default log-generator)
+//	sourcetype    event sourcetype (default _json)
+//	index         target index (default the token's default index)
+//	channel       request channel GUID (default random when ack or raw is used)
+//	ack           wait for indexer acknowledgement (default false)
+//	ack_timeout   how long a batch may stay unacknowledged (default 30s)
+//	ack_interval  how often acknowledgements are polled (default 1s)
+func newSplunkSink(cfg Config) (Sink, error) {
+	base := strings.TrimRight(cfg.String("url", ""), "/")
+	if base == "" {
+		return nil, fmt.Errorf("option url is required")
+	}
+	token := cfg.String("token", "")
+	if token == "" {
+		return nil, fmt.Errorf("option token is required")
+	}
+	endpoint := cfg.String("endpoint", "event")
+	if endpoint != "event" && endpoint != "raw" {
+		return nil, fmt.Errorf("option endpoint must be event or raw, got %q", endpoint)
+	}
+	ack, err := cfg.Bool("ack", false)
+	if err != nil {
+		return nil, err
+	}
+	ackTimeout, err := cfg.Duration("ack_timeout", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ackInterval, err := cfg.Duration("ack_interval", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+
+	p, err := newPoster(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p.header.Set("Authorization", "Splunk "+token)
+
+	s := &splunkSink{
+		raw:         endpoint == "raw",
+		host:        cfg.String("host", hostname),
+		source:      cfg.String("source", "log-generator"),
+		sourcetype:  cfg.String("sourcetype", "_json"),
+		index:       cfg.String("index", ""),
+		poster:      p,
+		ack:         ack,
+		ackURL:      base + "/services/collector/ack",
+		ackTimeout:  ackTimeout,
+		ackInterval: ackInterval,
+		pending:     make(map[int64]pendingAck),
+	}
+	p.stats = &s.counters
+
+	channel := cfg.String("channel", "")
+	if channel == "" && (ack || s.raw) {
+		if channel, err = newChannelID(); err != nil {
+			return nil, err
+		}
+	}
+	if channel != "" {
+		p.header.Set("X-Splunk-Request-Channel", channel)
+	}
+
+	if s.raw {
+		// The raw endpoint takes event metadata as query parameters
+		query := url.Values{}
+		query.Set("channel", channel)
+		query.Set("host", s.host)
+		query.Set("source", s.source)
+		query.Set("sourcetype", s.sourcetype)
+		if s.index != "" {
+			query.Set("index", s.index)
+		}
+		s.url = base + "/services/collector/raw?" + query.Encode()
+	} else {
+		s.url = base + "/services/collector/event"
+	}
+
+	if ack {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.pollAcks()
+	}
+	return s, nil
+}
+
+// hecEvent is the HEC event envelope
+type hecEvent struct {
+	Time       float64           `json:"time"`
+	Host       string            `json:"host,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Sourcetype string            `json:"sourcetype,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	Event      logentry.LogEntry `json:"event"`
+}
+
+func (s *splunkSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range logs {
+		var v interface{} = entry
+		if !s.raw {
+			ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+			if err != nil {
+				ts = time.Now()
+			}
+			v = hecEvent{
+				Time:       float64(ts.UnixNano()) / 1e9,
+				Host:       s.host,
+				Source:     s.source,
+				Sourcetype: s.sourcetype,
+				Index:      s.index,
+				Event:      entry,
+			}
+		}
+		if err := enc.Encode(v); err != nil {
+			s.failure(len(logs), err)
+			return fmt.Errorf("encoding HEC event: %w", err)
+		}
+	}
+
+	body := buf.Bytes()
+	respBody, err := s.poster.post(ctx, s.url, "application/json", body)
+	if err != nil {
+		s.failure(len(logs), err)
+		return err
+	}
+	if !s.ack {
+		s.success(len(logs), len(body))
+		return nil
+	}
+
+	var resp struct {
+		Code  int    `json:"code"`
+		Text  string `json:"text"`
+		AckID *int64 `json:"ackId"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil || resp.AckID == nil {
+		err = fmt.Errorf("HEC returned no ackId (is indexer acknowledgement enabled for the token?): %s", respBody)
+		s.failure(len(logs), err)
+		return err
+	}
+	s.pendingMux.Lock()
+	s.pending[*resp.AckID] = pendingAck{entries: len(logs), bytes: len(body), sent: time.Now()}
+	s.pendingMux.Unlock()
+	return nil
+}
+
+// Flush waits until every batch sent so far is acknowledged or has timed
+// out. Failed polls are retried until then; the error of the last poll, if
+// it failed, is returned.
+func (s *splunkSink) Flush(ctx context.Context) error {
+	if !s.ack {
+		return nil
+	}
+	ticker := time.NewTicker(s.ackInterval)
+	defer ticker.Stop()
+	for {
+		err := s.checkAcks(ctx)
+		s.pendingMux.Lock()
+		remaining := len(s.pending)
+		s.pendingMux.Unlock()
+		if remaining == 0 {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *splunkSink) Close() error {
+	if !s.ack {
+		return nil
+	}
+	err := s.Flush(context.Background())
+	close(s.stop)
+	<-s.done
+	return err
+}
+
+// pollAcks checks acknowledgements in the background so that the pending set
+// stays small during long runs
+func (s *splunkSink) pollAcks() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.ackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkAcks(context.Background())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// checkAcks queries the ack endpoint for every pending batch and counts the
+// indexed ones as delivered. Batches pending for longer than ack_timeout
+// count as failed whether or not the query succeeded, so that an
+// unreachable ack endpoint cannot hold them forever.
+func (s *splunkSink) checkAcks(ctx context.Context) error {
+	s.pendingMux.Lock()
+	ids := make([]int64, 0, len(s.pending))
+	for id := range s.pending {
+		ids = append(ids, id)
+	}
+	s.pendingMux.Unlock()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	acks, err := s.queryAcks(ctx, ids)
+
+	s.pendingMux.Lock()
+	defer s.pendingMux.Unlock()
+	for idStr, indexed := range acks {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil || !indexed {
+			continue
+		}
+		if p, ok := s.pending[id]; ok {
+			s.success(p.entries, p.bytes)
+			delete(s.pending, id)
+		}
+	}
+	for id, p := range s.pending {
+		if time.Since(p.sent) > s.ackTimeout {
+			s.failure(p.entries, fmt.Errorf("HEC ack %d not received within %s", id, s.ackTimeout))
+			delete(s.pending, id)
+		}
+	}
+	return err
+}
+
+// queryAcks asks HEC which of ids are indexed. Polls are not batches, so
+// they are neither compressed, retried nor counted in the stats.
+func (s *splunkSink) queryAcks(ctx context.Context, ids []int64) (map[string]bool, error) {
+	reqBody, err := json.Marshal(map[string][]int64{"acks": ids})
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := s.poster.query(ctx, s.ackURL, "application/json", reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("querying HEC acks: %w", err)
+	}
+	var resp struct {
+		Acks map[string]bool `json:"acks"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, fmt.Errorf("decoding HEC ack response: %w", err)
+	}
+	return resp.Acks, nil
+}
+
+// newChannelID returns a random version 4 UUID for the HEC request channel
+func newChannelID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generating channel id: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
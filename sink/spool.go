@@ -0,0 +1,557 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"log-generator/logentry"
+)
+
+const (
+	// spoolSegmentSize is the size at which the spool starts a new segment
+	// file. Segments are deleted once every batch in them was delivered.
+	spoolSegmentSize = 8 << 20
+	spoolCursorFile  = "cursor"
+
+	spoolMinBackoff = time.Second
+	spoolMaxBackoff = 30 * time.Second
+)
+
+// spoolOptions are the spool settings accepted by every sink spec
+var spoolOptions = []string{"spool", "spool_max_size", "spool_sync", "spool_drain"}
+
+// spool writes every batch to disk before a wrapped sink sends it. A single
+// goroutine replays the batches in order, retrying until the destination
+// accepts them, so an outage or a restart does not lose data.
+//
+// The spool directory holds numbered segment files with one JSON array per
+// line, and a cursor file with the segment and offset of the next batch to
+// deliver.
+type spool struct {
+	counters
+	inner   Sink
+	dir     string
+	maxSize int64
+	sync    bool
+	drain   time.Duration
+
+	mu        sync.Mutex
+	file      *os.File
+	writeSeg  int64
+	writeSize int64
+
+	pendingEntries atomic.Int64
+	pendingBytes   atomic.Int64
+
+	wake      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// spoolEntry keeps the generator of an entry, which LogEntry leaves out of
+// its JSON form
+type spoolEntry struct {
+	logentry.LogEntry
+	Generator string `json:"generator,omitempty"`
+}
+
+func spoolEntries(logs []logentry.LogEntry) []spoolEntry {
+	entries := make([]spoolEntry, len(logs))
+	for i, entry := range logs {
+		entries[i] = spoolEntry{LogEntry: entry, Generator: entry.Generator}
+	}
+	return entries
+}
+
+func logEntries(entries []spoolEntry) []logentry.LogEntry {
+	logs := make([]logentry.LogEntry, len(entries))
+	for i, e := range entries {
+		logs[i] = e.LogEntry
+		logs[i].Generator = e.Generator
+	}
+	return logs
+}
+
+// takeSpool removes the spool options from cfg. It returns nil settings when
+// the spec does not ask for a spool:
+//
+//	spool           directory holding the spool; enables spooling
+//	spool_max_size  pending data kept on disk before new batches are dropped (default 1GB)
+//	spool_sync      fsync every batch before it is acknowledged (default false)
+//	spool_drain     how long Flush and Close wait for the spool to empty (default 30s)
+func takeSpool(cfg Config) (Config, error) {
+	if cfg.String("spool", "") == "" {
+		for _, key := range spoolOptions {
+			if _, ok := cfg[key]; ok && key != "spool" {
+				return nil, fmt.Errorf("option %s needs option spool", key)
+			}
+		}
+		delete(cfg, "spool")
+		return nil, nil
+	}
+	opts := make(Config)
+	for _, key := range spoolOptions {
+		if v, ok := cfg[key]; ok {
+			opts[key] = v
+			delete(cfg, key)
+		}
+	}
+	return opts, nil
+}
+
+// newSpool wraps inner in a spool configured by the options returned from
+// takeSpool
+func newSpool(inner Sink, opts Config) (*spool, error) {
+	s := &spool{
+		inner: inner,
+		dir:   opts.String("spool", ""),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	var err error
+	if s.maxSize, err = opts.Size("spool_max_size", 1<<30); err != nil {
+		return nil, err
+	}
+	if s.sync, err = opts.Bool("spool_sync", false); err != nil {
+		return nil, err
+	}
+	if s.drain, err = opts.Duration("spool_drain", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+
+	seg, off, err := s.recover()
+	if err != nil {
+		return nil, err
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.replay(seg, off)
+	return s, nil
+}
+
+func (s *spool) segmentPath(seg int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d.seg", seg))
+}
+
+// segments lists the segment numbers in the spool directory in order
+func (s *spool) segments() ([]int64, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	var segs []int64
+	for _, m := range matches {
+		n, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(m), ".seg"), 10, 64)
+		if err == nil {
+			segs = append(segs, n)
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+// recover picks up the spool left by an earlier run. It drops a batch that
+// was cut short by a crash, counts what is still pending and opens the last
+// segment for appending. It returns where replay starts.
+func (s *spool) recover() (int64, int64, error) {
+	segs, err := s.segments()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading spool directory: %w", err)
+	}
+	seg, off := s.loadCursor()
+
+	if len(segs) == 0 {
+		segs = []int64{seg}
+	}
+	if seg < segs[0] || seg > segs[len(segs)-1] {
+		seg, off = segs[0], 0
+	}
+	for _, n := range segs {
+		if n < seg {
+			os.Remove(s.segmentPath(n))
+		}
+	}
+	if info, err := os.Stat(s.segmentPath(seg)); err != nil || info.Size() < off {
+		off = 0
+	}
+
+	s.writeSeg = segs[len(segs)-1]
+	if err := trimPartialRecord(s.segmentPath(s.writeSeg)); err != nil {
+		return 0, 0, err
+	}
+	for _, n := range segs {
+		if n < seg {
+			continue
+		}
+		start := int64(0)
+		if n == seg {
+			start = off
+		}
+		if err := s.countPending(n, start); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	f, err := os.OpenFile(s.segmentPath(s.writeSeg), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, 0, fmt.Errorf("opening spool segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, 0, fmt.Errorf("opening spool segment: %w", err)
+	}
+	s.file = f
+	s.writeSize = info.Size()
+	return seg, off, nil
+}
+
+// loadCursor reads the position of the next batch to deliver. A missing or
+// damaged cursor starts from the oldest segment.
+func (s *spool) loadCursor() (int64, int64) {
+	raw, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err != nil {
+		return 1, 0
+	}
+	var seg, off int64
+	if _, err := fmt.Sscanf(string(raw), "%d %d", &seg, &off); err != nil || seg < 1 || off < 0 {
+		return 1, 0
+	}
+	return seg, off
+}
+
+// saveCursor records that every batch before off in seg was handled
+func (s *spool) saveCursor(seg, off int64) error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	if err := os.WriteFile(path+".tmp", []byte(fmt.Sprintf("%d %d\n", seg, off)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// countPending adds the batches of seg from off onwards to the pending
+// counters
+func (s *spool) countPending(seg, off int64) error {
+	f, err := os.Open(s.segmentPath(seg))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading spool segment: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return fmt.Errorf("reading spool segment: %w", err)
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var records []json.RawMessage
+			json.Unmarshal(line, &records)
+			s.pendingEntries.Add(int64(len(records)))
+			s.pendingBytes.Add(int64(len(line)))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading spool segment: %w", err)
+		}
+	}
+}
+
+// trimPartialRecord truncates path after its last complete line
+func trimPartialRecord(path string) error {
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading spool segment: %w", err)
+	}
+	end := strings.LastIndexByte(string(raw), '\n') + 1
+	if end == len(raw) {
+		return nil
+	}
+	if err := os.Truncate(path, int64(end)); err != nil {
+		return fmt.Errorf("repairing spool segment: %w", err)
+	}
+	return nil
+}
+
+// Send appends logs to the spool. It fails only when the batch cannot be
+// written, or would grow the spool beyond spool_max_size.
+func (s *spool) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	line, err := json.Marshal(spoolEntries(logs))
+	if err != nil {
+		s.failure(len(logs), err)
+		return fmt.Errorf("encoding log entries: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		err := fmt.Errorf("spool is closed")
+		s.failure(len(logs), err)
+		return err
+	}
+	if pending := s.pendingBytes.Load(); s.maxSize > 0 && pending+int64(len(line)) > s.maxSize {
+		err := fmt.Errorf("spool full (%d of %d bytes pending), batch dropped", pending, s.maxSize)
+		s.failure(len(logs), err)
+		return err
+	}
+	if s.writeSize >= spoolSegmentSize {
+		if err := s.nextSegment(); err != nil {
+			s.failure(len(logs), err)
+			return err
+		}
+	}
+	if _, err := s.file.Write(line); err != nil {
+		// Drop what was written so the next batch starts on a fresh line
+		s.file.Truncate(s.writeSize)
+		err = fmt.Errorf("writing spool: %w", err)
+		s.failure(len(logs), err)
+		return err
+	}
+	if s.sync {
+		if err := s.file.Sync(); err != nil {
+			// The batch is reported as failed, so replay must not find it
+			s.file.Truncate(s.writeSize)
+			err = fmt.Errorf("syncing spool: %w", err)
+			s.failure(len(logs), err)
+			return err
+		}
+	}
+	s.writeSize += int64(len(line))
+	s.pendingEntries.Add(int64(len(logs)))
+	s.pendingBytes.Add(int64(len(line)))
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// nextSegment starts a new segment file. The caller holds mu.
+func (s *spool) nextSegment() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("closing spool segment: %w", err)
+	}
+	s.file = nil
+	f, err := os.OpenFile(s.segmentPath(s.writeSeg+1), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("creating spool segment: %w", err)
+	}
+	s.file = f
+	s.writeSeg++
+	s.writeSize = 0
+	return nil
+}
+
+// replay delivers spooled batches in order, starting at off in seg, until
+// the spool is closed
+func (s *spool) replay(seg, off int64) {
+	defer close(s.done)
+
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	for s.ctx.Err() == nil {
+		s.mu.Lock()
+		writeSeg, writeSize := s.writeSeg, s.writeSize
+		s.mu.Unlock()
+
+		if f == nil {
+			var err error
+			if f, err = os.Open(s.segmentPath(seg)); err != nil {
+				s.lastErr.Store(fmt.Sprintf("reading spool segment: %s", err))
+				s.wait(spoolMaxBackoff)
+				continue
+			}
+		}
+		limit := writeSize
+		if seg < writeSeg {
+			// Older segments are complete and no longer written
+			info, err := f.Stat()
+			if err != nil {
+				s.lastErr.Store(fmt.Sprintf("reading spool segment: %s", err))
+				s.wait(spoolMaxBackoff)
+				continue
+			}
+			limit = info.Size()
+		}
+
+		if off >= limit {
+			if seg < writeSeg {
+				f.Close()
+				f = nil
+				os.Remove(s.segmentPath(seg))
+				seg, off = seg+1, 0
+				s.saveCursor(seg, off)
+				continue
+			}
+			select {
+			case <-s.wake:
+			case <-s.ctx.Done():
+			}
+			continue
+		}
+
+		line, err := bufio.NewReader(io.NewSectionReader(f, off, limit-off)).ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			s.lastErr.Store(fmt.Sprintf("reading spool segment: %s", err))
+			s.wait(spoolMaxBackoff)
+			continue
+		}
+		entries, delivered := s.deliver(line)
+		if !delivered {
+			return
+		}
+		off += int64(len(line))
+		s.pendingEntries.Add(-int64(entries))
+		s.pendingBytes.Add(-int64(len(line)))
+		if err := s.saveCursor(seg, off); err != nil {
+			s.lastErr.Store(fmt.Sprintf("saving spool cursor: %s", err))
+		}
+	}
+}
+
+// deliver sends one spooled batch, retrying for as long as the failure is
+// retryable, and of a batch delivered in part only the rest. A batch the
+// destination rejects for good is dropped, or only its rejected entries if
+// it was accepted in part. It returns the number of
+// entries in the batch and false when the spool was closed before the batch
+// was handled.
+func (s *spool) deliver(line []byte) (int, bool) {
+	var entries []spoolEntry
+	if err := json.Unmarshal(line, &entries); err != nil {
+		s.failure(0, fmt.Errorf("decoding spooled batch: %w", err))
+		return 0, true
+	}
+	logs := logEntries(entries)
+	total := len(logs)
+
+	backoff := spoolMinBackoff
+	for {
+		err := s.inner.Send(s.ctx, logs)
+		if err == nil {
+			return total, true
+		}
+		if s.ctx.Err() != nil {
+			return total, false
+		}
+		partialErr, partial := asPartial(err)
+		if !Retryable(err) {
+			// Of a partly rejected batch only the rejected entries are lost
+			dropped := len(logs)
+			if partial {
+				dropped = partialErr.Failed
+			}
+			s.failure(dropped, err)
+			return total, true
+		}
+		// Entries delivered before the failure are not sent again
+		if partial && partialErr.Items != nil {
+			logs = pickEntries(logs, partialErr.Items)
+		}
+		s.lastErr.Store(err.Error())
+		s.wait(backoff)
+		if backoff *= 2; backoff > spoolMaxBackoff {
+			backoff = spoolMaxBackoff
+		}
+	}
+}
+
+// wait sleeps for d or until the spool is closed
+func (s *spool) wait(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.ctx.Done():
+	}
+}
+
+// Flush waits up to spool_drain for the spool to empty, then flushes the
+// wrapped sink. Batches still spooled afterwards stay on disk for later.
+func (s *spool) Flush(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.drain)
+	defer cancel()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.pendingBytes.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%d entries still spooled in %s", s.pendingEntries.Load(), s.dir)
+		}
+	}
+	return s.inner.Flush(ctx)
+}
+
+// Close drains the spool as Flush does, stops the replay and closes the
+// wrapped sink
+func (s *spool) Close() error {
+	var errs []error
+	s.closeOnce.Do(func() {
+		if err := s.Flush(context.Background()); err != nil {
+			errs = append(errs, err)
+		}
+		s.cancel()
+		<-s.done
+
+		s.mu.Lock()
+		if s.file != nil {
+			if err := s.file.Close(); err != nil {
+				errs = append(errs, err)
+			}
+			s.file = nil
+		}
+		s.mu.Unlock()
+
+		if err := s.inner.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Stats reports the delivery counters of the wrapped sink with the spool
+// depth. Failed attempts that will be retried are not counted as dropped.
+func (s *spool) Stats() Stats {
+	stats := s.inner.Stats()
+	own := s.counters.Stats()
+	stats.Failures += own.Failures
+	stats.Dropped = own.Dropped
+	if own.LastError != "" {
+		stats.LastError = own.LastError
+	}
+	stats.Spooled = s.pendingEntries.Load()
+	stats.SpoolBytes = s.pendingBytes.Load()
+	return stats
+}
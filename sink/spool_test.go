@@ -0,0 +1,210 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"log-generator/logentry"
+)
+
+// writeSegment writes one spooled batch per element of batches to segment
+// seg of dir, followed by tail
+func writeSegment(t *testing.T, dir string, seg int64, tail string, batches ...[]logentry.LogEntry) {
+	t.Helper()
+	var b strings.Builder
+	for _, logs := range batches {
+		line, err := json.Marshal(spoolEntries(logs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString(tail)
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%016d.seg", seg)), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openSpool(t *testing.T, inner Sink, dir string, opts ...string) *spool {
+	t.Helper()
+	cfg := Config{"spool": dir}
+	for i := 0; i+1 < len(opts); i += 2 {
+		cfg[opts[i]] = opts[i+1]
+	}
+	s, err := newSpool(inner, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSpoolRecoversTornLastLine(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, `[{"message":"cut short by a cra`, entries("a"), entries("b"))
+
+	inner := &fakeSink{}
+	s := openSpool(t, inner, dir)
+	// The next batch must start on a line of its own
+	if err := s.Send(context.Background(), entries("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inner.messages(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
+
+func TestSpoolReplaysSegmentsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 3, "", entries("e"))
+	writeSegment(t, dir, 1, "", entries("a", "b"), entries("c"))
+	writeSegment(t, dir, 2, "", entries("d"))
+
+	inner := &fakeSink{}
+	s := openSpool(t, inner, dir)
+	if err := s.Send(context.Background(), entries("f")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inner.messages(), []string{"a", "b", "c", "d", "e", "f"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+	segs, err := s.segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(segs, []int64{3}) {
+		t.Errorf("segments left %v, want only the one still written, 3", segs)
+	}
+}
+
+func TestSpoolCursorSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	first := &fakeSink{}
+	s := openSpool(t, first, dir)
+	if err := s.Send(context.Background(), entries("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := &fakeSink{}
+	s = openSpool(t, second, dir)
+	if err := s.Send(context.Background(), entries("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := first.messages(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first run delivered %q, want %q", got, want)
+	}
+	if got, want := second.messages(), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopening delivered %q, want only the new batch %q", got, want)
+	}
+}
+
+func TestSpoolResumesMidSegment(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, "", entries("a"), entries("b"), entries("c"))
+	line, _ := json.Marshal(spoolEntries(entries("a")))
+	cursor := fmt.Sprintf("1 %d\n", len(line)+1)
+	if err := os.WriteFile(filepath.Join(dir, spoolCursorFile), []byte(cursor), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	inner := &fakeSink{}
+	s := openSpool(t, inner, dir)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inner.messages(), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want the batches after the cursor %q", got, want)
+	}
+}
+
+func TestSpoolDropsWhenFull(t *testing.T) {
+	dir := t.TempDir()
+	inner := &fakeSink{fail: func([]logentry.LogEntry) error { return errors.New("connection refused") }}
+	s := openSpool(t, inner, dir, "spool_max_size", "100", "spool_drain", "10ms")
+	defer s.Close()
+
+	if err := s.Send(context.Background(), entries("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), entries("b")); err == nil || !strings.Contains(err.Error(), "spool full") {
+		t.Fatalf("Send to a full spool = %v, want a spool full error", err)
+	}
+	stats := s.Stats()
+	if stats.Spooled != 1 || stats.Dropped != 1 {
+		t.Errorf("Spooled = %d, Dropped = %d, want 1 and 1", stats.Spooled, stats.Dropped)
+	}
+}
+
+func TestSpoolPartialFailures(t *testing.T) {
+	t.Run("rejected entries are dropped", func(t *testing.T) {
+		inner := &fakeSink{fail: func(logs []logentry.LogEntry) error {
+			if len(logs) == 3 {
+				return &PartialError{Total: 3, Failed: 1, Items: []int{1}, Err: errors.New("1 of 3 rejected")}
+			}
+			return nil
+		}}
+		s := openSpool(t, inner, t.TempDir())
+		if err := s.Send(context.Background(), entries("a", "b", "c")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Send(context.Background(), entries("d")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if inner.sends != 2 {
+			t.Errorf("%d sends, want 2: a partly rejected batch must not be sent again", inner.sends)
+		}
+		if got := s.Stats().Dropped; got != 1 {
+			t.Errorf("Dropped = %d, want the 1 rejected entry", got)
+		}
+	})
+
+	t.Run("only unsent entries are resent", func(t *testing.T) {
+		var delivered []string
+		inner := &fakeSink{fail: func(logs []logentry.LogEntry) error {
+			if len(delivered) == 0 {
+				// The first entry gets through, then the connection breaks
+				delivered = append(delivered, logs[0].Message)
+				return &PartialError{Total: 3, Failed: 2, Items: []int{1, 2}, Retry: true, Err: errors.New("connection reset")}
+			}
+			for _, entry := range logs {
+				delivered = append(delivered, entry.Message)
+			}
+			return nil
+		}}
+		s := openSpool(t, inner, t.TempDir())
+		if err := s.Send(context.Background(), entries("a", "b", "c")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(delivered, want) {
+			t.Errorf("delivered %q, want each entry once %q", delivered, want)
+		}
+		if got := s.Stats().Dropped; got != 0 {
+			t.Errorf("Dropped = %d, want 0", got)
+		}
+	})
+}
@@ -0,0 +1,415 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"log-generator/logentry"
+)
+
+func init() {
+	Register("syslog", newSyslogSink)
+}
+
+// syslogSeverity maps generator levels onto syslog severities
+var syslogSeverity = map[string]int{
+	"FATAL": 2,
+	"ERROR": 3,
+	"WARN":  4,
+	"INFO":  6,
+	"DEBUG": 7,
+	"TRACE": 7,
+}
+
+// syslogFacilities names the facilities accepted by the facility option
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSink renders entries as RFC 5424 or RFC 3164 messages and writes
+// them to a UDP, TCP or TLS connection
+type syslogSink struct {
+	counters
+	network  string
+	address  string
+	rfc3164  bool
+	octet    bool
+	maxSize  int
+	facility int
+	hostname string
+	procID   string
+	sdID     string
+	timeout  time.Duration
+	dialer   *streamDialer
+
+	connMux sync.Mutex
+	conn    net.Conn
+}
+
+// newSyslogSink reads:
+//
+//	address   host:port of the receiver (required)
+//	network   udp, tcp or tls (default udp)
+//	format    rfc5424 or rfc3164 (default rfc5424)
+//	framing   octet (RFC 6587 octet counting) or newline, for tcp and tls (default octet)
+//	max_size  largest UDP message, longer ones are truncated (default 2048 for rfc5424, 1024 for rfc3164)
+//	facility  facility name or number (default local0)
+//	hostname  HOSTNAME field (default the local hostname)
+//	sd_id     SD-ID of the metadata element (default meta@32473)
+//	timeout   dial and write timeout (default 10s)
+//
+// It also accepts the TLS options of readTLSOptions for network tls and the
+// proxy option of readProxy for tcp and tls.
+func newSyslogSink(cfg Config) (Sink, error) {
+	address := cfg.String("address", "")
+	if address == "" {
+		return nil, fmt.Errorf("option address is required")
+	}
+	network := cfg.String("network", "udp")
+	if network != "udp" && network != "tcp" && network != "tls" {
+		return nil, fmt.Errorf("option network must be udp, tcp or tls, got %q", network)
+	}
+	format := cfg.String("format", "rfc5424")
+	if format != "rfc5424" && format != "rfc3164" {
+		return nil, fmt.Errorf("option format must be rfc5424 or rfc3164, got %q", format)
+	}
+	framing := cfg.String("framing", "octet")
+	if framing != "octet" && framing != "newline" {
+		return nil, fmt.Errorf("option framing must be octet or newline, got %q", framing)
+	}
+	defaultMax := 2048
+	if format == "rfc3164" {
+		defaultMax = 1024
+	}
+	maxSize, err := cfg.Int("max_size", defaultMax)
+	if err != nil {
+		return nil, err
+	}
+	facility, ok := syslogFacilities[cfg.String("facility", "local0")]
+	if !ok {
+		facility, err = strconv.Atoi(cfg["facility"])
+		if err != nil || facility < 0 || facility > 23 {
+			return nil, fmt.Errorf("option facility: unknown facility %q", cfg["facility"])
+		}
+	}
+	timeout, err := cfg.Duration("timeout", defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	dialNetwork := network
+	if network == "tls" {
+		dialNetwork = "tcp"
+	}
+	dialer, err := newStreamDialer(cfg, dialNetwork, address, network == "tls", timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &syslogSink{
+		network:  network,
+		rfc3164:  format == "rfc3164",
+		octet:    framing == "octet",
+		maxSize:  maxSize,
+		facility: facility,
+		hostname: cfg.String("hostname", hostname),
+		procID:   strconv.Itoa(os.Getpid()),
+		sdID:     cfg.String("sd_id", "meta@32473"),
+		timeout:  timeout,
+		dialer:   dialer,
+	}
+	return s, nil
+}
+
+func (s *syslogSink) Send(ctx context.Context, logs []logentry.LogEntry) error {
+	var buf bytes.Buffer
+	msgs := make([][]byte, 0, len(logs))
+	for _, entry := range logs {
+		var head, text string
+		if s.rfc3164 {
+			head, text = s.format3164(entry)
+		} else {
+			head, text = s.format5424(entry)
+		}
+		if s.network == "udp" {
+			msgs = append(msgs, truncateSyslog(head, text, s.maxSize))
+			continue
+		}
+		msg := []byte(head + text)
+		if s.octet {
+			buf.WriteString(strconv.Itoa(len(msg)))
+			buf.WriteByte(' ')
+			buf.Write(msg)
+		} else {
+			buf.Write(msg)
+			buf.WriteByte('\n')
+		}
+	}
+
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+
+	var written int
+	var err error
+	if s.network == "udp" {
+		// Each datagram carries exactly one message
+		for _, msg := range msgs {
+			if _, err = s.write(ctx, msg); err != nil {
+				break
+			}
+			written += len(msg)
+		}
+	} else {
+		// A stream connection may have been closed by the receiver since the
+		// last batch, so reconnect once before giving up. Only a batch of
+		// which nothing was written is sent again, resending after a partial
+		// write would leave the receiver a broken frame and duplicates.
+		var n int
+		if n, err = s.write(ctx, buf.Bytes()); err != nil && n == 0 {
+			s.closeConn()
+			_, err = s.write(ctx, buf.Bytes())
+		}
+		written = buf.Len()
+	}
+	if err != nil {
+		s.closeConn()
+		s.failure(len(logs), err)
+		return err
+	}
+	s.success(len(logs), written)
+	return nil
+}
+
+// write sends b on the connection, dialing first if needed, and returns how
+// much of b was written. The caller holds connMux.
+func (s *syslogSink) write(ctx context.Context, b []byte) (int, error) {
+	if s.conn == nil {
+		conn, err := s.dialer.dial(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("connecting to syslog receiver: %w", err)
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	n, err := s.conn.Write(b)
+	if err != nil {
+		return n, fmt.Errorf("writing syslog message: %w", err)
+	}
+	return n, nil
+}
+
+func (s *syslogSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+func (s *syslogSink) Flush(ctx context.Context) error { return nil }
+
+func (s *syslogSink) Close() error {
+	s.connMux.Lock()
+	defer s.connMux.Unlock()
+	s.closeConn()
+	return nil
+}
+
+func (s *syslogSink) pri(entry logentry.LogEntry) int {
+	severity, ok := syslogSeverity[strings.ToUpper(entry.Level)]
+	if !ok {
+		severity = 5 // notice
+	}
+	return s.facility*8 + severity
+}
+
+// format5424 renders
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [log@32473 ...][meta@32473 ...] MSG
+//
+// with the entry's own fields in the log element and its metadata in the
+// element named by sd_id. It returns everything up to MSG as head.
+func (s *syslogSink) format5424(entry logentry.LogEntry) (head, msg string) {
+	ts := entry.Timestamp
+	if _, err := time.Parse(time.RFC3339, ts); err != nil {
+		ts = time.Now().Format(time.RFC3339)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ",
+		s.pri(entry),
+		ts,
+		syslogHeaderField(s.hostname, 255),
+		syslogHeaderField(entry.Service, 48),
+		s.procID,
+		syslogHeaderField(entry.Action, 32),
+	)
+
+	var params [][2]string
+	add := func(name, value string) {
+		if value != "" {
+			params = append(params, [2]string{name, value})
+		}
+	}
+	add("environment", entry.Environment)
+	if entry.StatusCode != 0 {
+		add("status_code", strconv.Itoa(entry.StatusCode))
+	}
+	add("method", entry.Method)
+	add("path", entry.Path)
+	if entry.Duration != 0 {
+		add("duration", strconv.Itoa(entry.Duration))
+	}
+	add("user_id", entry.UserID)
+
+	sd := syslogSDElement("log@32473", params)
+	if meta := syslogMetadataParams(entry.Metadata); len(meta) > 0 {
+		sd += syslogSDElement(s.sdID, meta)
+	}
+	if sd == "" {
+		sd = "-"
+	}
+	b.WriteString(sd)
+	if entry.Message == "" {
+		return b.String(), ""
+	}
+	b.WriteByte(' ')
+	return b.String(), entry.Message
+}
+
+// format3164 renders the BSD format <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+// and returns everything up to MSG as head
+func (s *syslogSink) format3164(entry logentry.LogEntry) (head, msg string) {
+	ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+	if err != nil {
+		ts = time.Now()
+	}
+	tag := syslogHeaderField(entry.Service, 32)
+	if tag == "-" {
+		tag = "log-generator"
+	}
+	head = fmt.Sprintf("<%d>%s %s %s[%s]: ",
+		s.pri(entry),
+		ts.Local().Format(time.Stamp),
+		syslogHeaderField(s.hostname, 255),
+		tag,
+		s.procID,
+	)
+	return head, entry.Message
+}
+
+// syslogHeaderField restricts a header field to printable ASCII without
+// spaces and to max bytes, using the NILVALUE - when nothing remains
+func syslogHeaderField(v string, max int) string {
+	out := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(out) < max; i++ {
+		if c := v[i]; c > 32 && c < 127 {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return "-"
+	}
+	return string(out)
+}
+
+// syslogMetadataParams flattens metadata into SD-PARAMs sorted by name.
+// Values that are not strings or numbers are written as JSON.
+func syslogMetadataParams(metadata interface{}) [][2]string {
+	if metadata == nil {
+		return nil
+	}
+	fields, ok := metadata.(map[string]interface{})
+	if !ok {
+		raw, err := json.Marshal(metadata)
+		if err != nil || json.Unmarshal(raw, &fields) != nil {
+			return [][2]string{{"metadata", string(raw)}}
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := make([][2]string, 0, len(names))
+	for _, name := range names {
+		var value string
+		switch v := fields[name].(type) {
+		case string:
+			value = v
+		case int, int64, float64, bool:
+			value = fmt.Sprint(v)
+		default:
+			raw, _ := json.Marshal(v)
+			value = string(raw)
+		}
+		params = append(params, [2]string{name, value})
+	}
+	return params
+}
+
+// syslogSDElement renders [ID name="value" ...], escaping ", \ and ] in
+// values and dropping characters SD-NAMEs may not contain
+func syslogSDElement(id string, params [][2]string) string {
+	if len(params) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('[')
+	b.WriteString(id)
+	for _, p := range params {
+		name := strings.Map(func(r rune) rune {
+			if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+				return -1
+			}
+			return r
+		}, p[0])
+		if len(name) > 32 {
+			name = name[:32]
+		}
+		if name == "" {
+			continue
+		}
+		b.WriteByte(' ')
+		b.WriteString(name)
+		b.WriteString(`="`)
+		for _, r := range p[1] {
+			if r == '"' || r == '\\' || r == ']' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		b.WriteByte('"')
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// truncateSyslog joins head and msg into a UDP message of at most max
+// bytes. Only MSG is shortened, at a UTF-8 boundary, so the header and
+// structured data stay parseable; a head that alone exceeds max is cut as
+// well.
+func truncateSyslog(head, msg string, max int) []byte {
+	full := []byte(head + msg)
+	if max <= 0 || len(full) <= max {
+		return full
+	}
+	cut := max
+	for cut > len(head) && !utf8.RuneStart(full[cut]) {
+		cut--
+	}
+	return full[:cut]
+}
@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"log-generator/logentry"
+)
+
+// entryTemplate builds names such as index names or tags from an entry. Text
+// inside braces is replaced by the lowercased entry field of that name (see
+// entryField), or by unknown when the field is empty. {date:LAYOUT} is
+// replaced by the entry timestamp in UTC formatted with the Go time layout
+// LAYOUT. All other text is copied verbatim, so logs-{environment}-{date:2006.01.02}
+// becomes logs-production-2024.05.17. Literal text holding a layout, as in
+// logs-2006.01.02, is rejected, as it would name the same index forever.
+type entryTemplate []templateSegment
+
+type templateSegment struct {
+	text  string
+	field bool
+	// date marks a {date:LAYOUT} segment, text holds the layout
+	date bool
+}
+
+func parseEntryTemplate(tmpl string) (entryTemplate, error) {
+	orig := tmpl
+	var segments entryTemplate
+	literal := func(text string) error {
+		if layout := literalLayout(text); layout != "" {
+			return fmt.Errorf("template %q: %s would be copied as is and never change, write the date as {date:%s}", orig, layout, layout)
+		}
+		segments = append(segments, templateSegment{text: text})
+		return nil
+	}
+	for tmpl != "" {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			if err := literal(tmpl); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if open > 0 {
+			if err := literal(tmpl[:open]); err != nil {
+				return nil, err
+			}
+		}
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unclosed {", tmpl)
+		}
+		field := tmpl[open+1 : open+end]
+		if layout, ok := strings.CutPrefix(field, "date:"); ok {
+			if layout == "" {
+				return nil, fmt.Errorf("template: {date:} needs a Go time layout, e.g. {date:2006.01.02}")
+			}
+			segments = append(segments, templateSegment{text: layout, date: true})
+		} else if _, ok := entryField(logentry.LogEntry{}, field); ok {
+			segments = append(segments, templateSegment{text: field, field: true})
+		} else {
+			return nil, fmt.Errorf("template: unknown field {%s}", field)
+		}
+		tmpl = tmpl[open+end+1:]
+	}
+	return segments, nil
+}
+
+// literalLayout returns the part of literal template text that looks like a
+// Go time layout, such as the 2006.01.02 of logs-2006.01.02, or "" if there
+// is none
+func literalLayout(text string) string {
+	i := strings.Index(text, "2006")
+	if i < 0 {
+		return ""
+	}
+	inLayout := func(c byte) bool { return c >= '0' && c <= '9' || strings.IndexByte(".-_/", c) >= 0 }
+	start, end := i, i+len("2006")
+	for start > 0 && inLayout(text[start-1]) {
+		start--
+	}
+	for end < len(text) && inLayout(text[end]) {
+		end++
+	}
+	return strings.Trim(text[start:end], ".-_/")
+}
+
+func (t entryTemplate) render(entry logentry.LogEntry) string {
+	var sb strings.Builder
+	for _, seg := range t {
+		switch {
+		case seg.field:
+			sb.WriteString(templateField(entry, seg.text))
+		case seg.date:
+			ts, err := time.Parse(time.RFC3339, entry.Timestamp)
+			if err != nil {
+				ts = time.Now()
+			}
+			sb.WriteString(ts.UTC().Format(seg.text))
+		default:
+			sb.WriteString(seg.text)
+		}
+	}
+	return sb.String()
+}
+
+func templateField(entry logentry.LogEntry, name string) string {
+	v, _ := entryField(entry, name)
+	if v == "" {
+		return "unknown"
+	}
+	return strings.ToLower(v)
+}
@@ -0,0 +1,56 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+
+	"log-generator/logentry"
+)
+
+func TestEntryTemplate(t *testing.T) {
+	entry := logentry.LogEntry{
+		Timestamp:   "2024-05-17T23:30:00+02:00",
+		Environment: "Production",
+		Service:     "api-gateway",
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"logs-{date:2006.01.02}", "logs-2024.05.17"},
+		{"logs-{environment}-{date:2006.01.02}", "logs-production-2024.05.17"},
+		// Literal text is never formatted, even where it holds layout tokens
+		{"logs-v1-Jan-{service}", "logs-v1-Jan-api-gateway"},
+		{"{level}", "unknown"},
+		{"apm-{date:2006-01}-15", "apm-2024-05-15"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseEntryTemplate(tt.tmpl)
+		if err != nil {
+			t.Errorf("parseEntryTemplate(%q): %v", tt.tmpl, err)
+			continue
+		}
+		if got := tmpl.render(entry); got != tt.want {
+			t.Errorf("%q rendered %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestEntryTemplateErrors(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"logs-{environment}-2006.01.02", "write the date as {date:2006.01.02}"},
+		{"logs-01-02-2006-{service}", "write the date as {date:01-02-2006}"},
+		{"logs-{date:}", "needs a Go time layout"},
+		{"logs-{nope}", "unknown field {nope}"},
+		{"logs-{service", "unclosed {"},
+	}
+	for _, tt := range tests {
+		_, err := parseEntryTemplate(tt.tmpl)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseEntryTemplate(%q) = %v, want an error containing %q", tt.tmpl, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// tlsOptions are the TLS settings of a sink as given in its spec. It is
+// comparable, so that HTTP sinks with equal settings share a transport.
+type tlsOptions struct {
+	ca         string
+	cert       string
+	key        string
+	serverName string
+	minVersion string
+	insecure   bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// readTLSOptions reads:
+//
+//	tls_ca           PEM file with the CAs to trust instead of the system pool
+//	tls_cert         PEM client certificate for mutual TLS, with tls_key
+//	tls_key          PEM private key of tls_cert
+//	tls_server_name  name the server certificate must match (default the host dialed)
+//	tls_min_version  1.0, 1.1, 1.2 or 1.3 (default 1.2)
+//	tls_insecure     skip certificate verification, for lab setups only (default false)
+func readTLSOptions(cfg Config) (tlsOptions, error) {
+	o := tlsOptions{
+		ca:         cfg.String("tls_ca", ""),
+		cert:       cfg.String("tls_cert", ""),
+		key:        cfg.String("tls_key", ""),
+		serverName: cfg.String("tls_server_name", ""),
+		minVersion: cfg.String("tls_min_version", "1.2"),
+	}
+	var err error
+	if o.insecure, err = cfg.Bool("tls_insecure", false); err != nil {
+		return o, err
+	}
+	if (o.cert == "") != (o.key == "") {
+		return o, fmt.Errorf("options tls_cert and tls_key must be given together")
+	}
+	if _, ok := tlsVersions[o.minVersion]; !ok {
+		return o, fmt.Errorf("option tls_min_version must be 1.0, 1.1, 1.2 or 1.3, got %q", o.minVersion)
+	}
+	return o, nil
+}
+
+// custom reports whether any setting differs from Go's defaults
+func (o tlsOptions) custom() bool {
+	return o != tlsOptions{minVersion: "1.2"}
+}
+
+// config builds the client TLS configuration. serverName is used when
+// tls_server_name is not set; it may be empty to let the caller fill it in
+// from the address dialed.
+func (o tlsOptions) config(serverName string) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName:         serverName,
+		MinVersion:         tlsVersions[o.minVersion],
+		InsecureSkipVerify: o.insecure,
+	}
+	if o.serverName != "" {
+		c.ServerName = o.serverName
+	}
+	if o.ca != "" {
+		pem, err := os.ReadFile(o.ca)
+		if err != nil {
+			return nil, fmt.Errorf("option tls_ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("option tls_ca: no PEM certificates in %s", o.ca)
+		}
+		c.RootCAs = pool
+	}
+	if o.cert != "" {
+		cert, err := tls.LoadX509KeyPair(o.cert, o.key)
+		if err != nil {
+			return nil, fmt.Errorf("options tls_cert and tls_key: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// transportKey holds the settings that tell shared transports apart
+type transportKey struct {
+	maxIdleConns int
+	idleTimeout  time.Duration
+	http2        bool
+	tls          tlsOptions
+	proxy        string
+}
+
+var (
+	transportsMux sync.Mutex
+	transports    = make(map[transportKey]*http.Transport)
+)
+
+// newTransportKey reads:
+//
+//	max_idle_conns  keep-alive connections kept open per host (default 100)
+//	idle_timeout    close keep-alive connections idle this long (default 90s)
+//	http2           negotiate HTTP/2 with TLS destinations (default true)
+//
+// plus the TLS options of readTLSOptions and the proxy option of readProxy.
+// Without a proxy option the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables apply.
+func newTransportKey(cfg Config) (transportKey, error) {
+	var k transportKey
+	var err error
+	if k.maxIdleConns, err = cfg.Int("max_idle_conns", 100); err != nil {
+		return k, err
+	}
+	if k.idleTimeout, err = cfg.Duration("idle_timeout", 90*time.Second); err != nil {
+		return k, err
+	}
+	if k.http2, err = cfg.Bool("http2", true); err != nil {
+		return k, err
+	}
+	if k.tls, err = readTLSOptions(cfg); err != nil {
+		return k, err
+	}
+	if _, _, err = readProxy(cfg); err != nil {
+		return k, err
+	}
+	k.proxy = cfg.String("proxy", "")
+	return k, nil
+}
+
+// sharedTransport returns the transport for k, creating it on first use.
+// Sinks with the same settings share one connection pool, so keep-alive
+// connections are reused across batches and sinks.
+func sharedTransport(k transportKey) (*http.Transport, error) {
+	transportsMux.Lock()
+	defer transportsMux.Unlock()
+
+	if t, ok := transports[k]; ok {
+		return t, nil
+	}
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     k.http2,
+		MaxIdleConns:          k.maxIdleConns * 4,
+		MaxIdleConnsPerHost:   k.maxIdleConns,
+		IdleConnTimeout:       k.idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if k.tls.custom() {
+		// The transport fills in the server name from each request's host
+		tlsConfig, err := k.tls.config("")
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+	proxyURL, none, _ := readProxy(Config{"proxy": k.proxy})
+	switch {
+	case none:
+		t.Proxy = nil
+	case proxyURL != nil:
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	if !k.http2 {
+		// A non-nil empty map turns off the built-in HTTP/2 support
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	transports[k] = t
+	return t, nil
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	stdlog "log"
 	"net/http"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/gorilla/websocket"
+
+	"log-generator/generator"
+	"log-generator/sink"
 )
 
 // Configuration constants
@@ -87,6 +92,7 @@ func startWebServer() {
 	http.HandleFunc("/ws", handleWebSocket)
 	http.HandleFunc("/start", handleStart)
 	http.HandleFunc("/stop", handleStop)
+	http.HandleFunc("/status", handleStatus)
 
 	fmt.Println("Web server started at http://localhost:8090")
 	if err := http.ListenAndServe(":8090", nil); err != nil {
@@ -168,6 +174,18 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A JSON load profile in the body overrides --profile for this run
+	profile := defaultProfile
+	if body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)); err != nil {
+		http.Error(w, "Error reading request", http.StatusBadRequest)
+		return
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		if profile, err = generator.ParseProfile(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	runningMux.Lock()
 	if isRunning {
 		runningMux.Unlock()
@@ -177,22 +195,23 @@ func handleStart(w http.ResponseWriter, r *http.Request) {
 	// Create new stop channel
 	stopChan = make(chan struct{})
 	isRunning = true
+	profileRun = profile.Start()
+	run := profileRun
+	stop := stopChan
 	runningMux.Unlock()
 
 	// Start the log generators
 	var wg sync.WaitGroup
-	wg.Add(4)
-
-	go generateAPILogs(&wg)
-	go generateDatabaseLogs(&wg)
-	go generateUserActivityLogs(&wg)
-	go generateSystemMetrics(&wg)
+	startGenerators(&wg, stop, run)
 
 	// Start a goroutine to wait for completion
 	go func() {
 		wg.Wait()
 		runningMux.Lock()
-		isRunning = false
+		// A /stop and /start may have begun another run meanwhile
+		if stopChan == stop {
+			isRunning = false
+		}
 		runningMux.Unlock()
 	}()
 
@@ -211,20 +230,39 @@ func handleStop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	runningMux.Lock()
-	if !isRunning {
-		runningMux.Unlock()
+	if !stopLogGeneration(r.Context()) {
 		http.Error(w, "Log generation not running", http.StatusBadRequest)
 		return
 	}
-	// Close the stop channel
-	close(stopChan)
-	isRunning = false
+	w.Write([]byte("Log generation stopped"))
+}
+
+// StatusData is the delivery state reported by /status
+type StatusData struct {
+	Running  bool                    `json:"running"`
+	Sinks    []sink.TargetStats      `json:"sinks"`
+	Adaptive *sink.AdaptiveState     `json:"adaptive,omitempty"`
+	Profile  *generator.ProfileState `json:"profile,omitempty"`
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	runningMux.Lock()
+	data := StatusData{Running: isRunning}
+	if isRunning && profileRun != nil {
+		state := profileRun.State()
+		data.Profile = &state
+	}
 	runningMux.Unlock()
 
-	// Wait a bit longer to ensure all goroutines have stopped
-	time.Sleep(500 * time.Millisecond)
-	w.Write([]byte("Log generation stopped"))
+	data.Sinks = logSink.TargetStats()
+	if adaptive != nil {
+		state := adaptive.State()
+		data.Adaptive = &state
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		stdlog.Printf("Error writing status: %v", err)
+	}
 }
 
 func broadcastLog(log LogEntry) {